@@ -2,77 +2,197 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
 )
 
-const (
-	AniListURL = "https://graphql.anilist.co"
-	// Default timeout for requests in seconds
-	DefaultTimeout = 10
-)
-
+// AniListClient queries the AniList GraphQL API. It is safe for concurrent use:
+// identical concurrent queries share one request, all requests share one rate limiter,
+// and failed requests are retried with backoff.
 type AniListClient struct {
 	httpClient *http.Client
+	url        string
+	maxRetries int
+	retryDelay time.Duration
+	limiter    *limiter
+
+	calls sharedCalls // Coalesces identical queries in flight
+	stats queryStats
+
+	franchiseTTL time.Duration
+	franchises   franchiseCache
 }
 
 // NewAniListClient creates a new client for interacting with AniList API
-func NewAniListClient() *AniListClient {
+func NewAniListClient(settings config.AniListSettings) *AniListClient {
 	return &AniListClient{
 		httpClient: &http.Client{
-			Timeout: time.Second * DefaultTimeout,
+			Timeout: settings.Timeout,
 		},
+		url:        settings.GraphQLURL,
+		maxRetries: settings.MaxRetries,
+		retryDelay: settings.RetryBaseDelay,
+		limiter:    newLimiter(settings.RateLimit),
+
+		franchiseTTL: settings.FranchiseTTL,
 	}
 }
 
-// GetAnimeByID fetches anime details from AniList by ID
-func (c *AniListClient) GetAnimeByID(id int) (*models.AnimeDetails, error) {
-	query := `
-    query ($id: Int) {
-        Media(id: $id, type: ANIME) {
-            id
-            title {
-                romaji
-                english
-                native
-            }
-            description
-            format
-            status
-            episodes
-            duration
-            genres
-            startDate {
-                year
-                month
-                day
+// mediaDetailsFields are the Media fields decoded into models.AnimeDetails
+const mediaDetailsFields = `
+    id
+    title {
+        romaji
+        english
+        native
+    }
+    synonyms
+    description
+    format
+    status
+    episodes
+    duration
+    genres
+    startDate {
+        year
+        month
+        day
+    }
+    endDate {
+        year
+        month
+        day
+    }
+    season
+    seasonYear
+    coverImage {
+        large
+        medium
+    }
+    bannerImage
+    averageScore
+    popularity
+    studios {
+        nodes {
+            name
+        }
+    }
+    nextAiringEpisode {
+        episode
+        airingAt
+    }
+`
+
+// mediaExtendedFields are the sections of a single anime's details that are too
+// expensive to fetch in batches: tags, characters, staff, relations and links
+const mediaExtendedFields = `
+    tags {
+        id
+        name
+        description
+        category
+        rank
+        isGeneralSpoiler
+        isMediaSpoiler
+        isAdult
+    }
+    characters(sort: [ROLE, RELEVANCE, ID], perPage: 25) {
+        edges {
+            role
+            node {
+                id
+                name { full native }
+                image { medium }
             }
-            endDate {
-                year
-                month
-                day
+            voiceActors(sort: [RELEVANCE, ID]) {
+                id
+                name { full native }
+                languageV2
+                image { medium }
             }
-            season
-            seasonYear
-            coverImage {
-                large
-                medium
+        }
+    }
+    staff(sort: [RELEVANCE, ID], perPage: 25) {
+        edges {
+            role
+            node {
+                id
+                name { full native }
+                image { medium }
             }
-            bannerImage
-            averageScore
-            popularity
-            studios {
-                nodes {
-                    name
-                }
+        }
+    }
+    relations {
+        edges {
+            relationType(version: 2)
+            node {
+                id
+                type
+                format
+                status
+                title { romaji english }
+                coverImage { medium }
             }
         }
     }
+    trailer {
+        id
+        site
+        thumbnail
+    }
+    externalLinks {
+        id
+        url
+        site
+        type
+        language
+    }
+`
+
+// mediaSummaryFields are the Media fields of search results and other pages,
+// enough to fill the catalogue columns of models.AnimeCache
+const mediaSummaryFields = `
+    id
+    title { romaji english native }
+    synonyms
+    coverImage { large }
+    format
+    episodes
+    status
+    season
+    seasonYear
+    genres
+    studios { nodes { name } }
+`
+
+// maxPerPage is the largest page AniList returns
+const maxPerPage = 50
+
+// GetAnimeByID fetches anime details from AniList by ID, without the extended sections
+func (c *AniListClient) GetAnimeByID(ctx context.Context, id int) (*models.AnimeDetails, error) {
+	return c.getMedia(ctx, id, mediaDetailsFields)
+}
+
+// GetAnimeDetailsByID fetches anime details from AniList by ID, including the extended sections
+func (c *AniListClient) GetAnimeDetailsByID(ctx context.Context, id int) (*models.AnimeDetails, error) {
+	return c.getMedia(ctx, id, mediaDetailsFields+mediaExtendedFields)
+}
+
+// getMedia fetches the given Media fields of one anime
+func (c *AniListClient) getMedia(ctx context.Context, id int, fields string) (*models.AnimeDetails, error) {
+	query := `
+    query ($id: Int) {
+        Media(id: $id, type: ANIME) {` + fields + `}
+    }
     `
 
 	variables := map[string]interface{}{
@@ -80,9 +200,9 @@ func (c *AniListClient) GetAnimeByID(id int) (*models.AnimeDetails, error) {
 	}
 
 	// Execute the query
-	response, err := c.executeQuery(query, variables)
+	response, err := c.executeQuery(ctx, query, variables)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch anime: %v", err)
+		return nil, fmt.Errorf("failed to fetch anime: %w", err)
 	}
 
 	// Parse the response
@@ -95,35 +215,60 @@ func (c *AniListClient) GetAnimeByID(id int) (*models.AnimeDetails, error) {
 	if err := json.Unmarshal(response, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse anime data: %v", err)
 	}
+	if result.Data.Media == nil {
+		return nil, ErrNotFound
+	}
 
 	return result.Data.Media, nil
 }
 
+// GetAnimeByIDs fetches the details of many anime, in pages of 50 IDs.
+// Anime unknown to AniList are left out of the result, which is in no particular order.
+func (c *AniListClient) GetAnimeByIDs(ctx context.Context, ids []int) ([]models.AnimeDetails, error) {
+	query := `
+    query ($ids: [Int], $perPage: Int) {
+        Page(page: 1, perPage: $perPage) {
+            media(id_in: $ids, type: ANIME) {` + mediaDetailsFields + `}
+        }
+    }
+    `
+
+	animes := make([]models.AnimeDetails, 0, len(ids))
+	for start := 0; start < len(ids); start += maxPerPage {
+		chunk := ids[start:min(start+maxPerPage, len(ids))]
+		variables := map[string]interface{}{
+			"ids":     chunk,
+			"perPage": maxPerPage,
+		}
+
+		response, err := c.executeQuery(ctx, query, variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch anime: %w", err)
+		}
+
+		var result struct {
+			Data struct {
+				Page struct {
+					Media []models.AnimeDetails `json:"media"`
+				} `json:"Page"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(response, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse anime data: %v", err)
+		}
+		animes = append(animes, result.Data.Page.Media...)
+	}
+
+	return animes, nil
+}
+
 // SearchAnime performs a search query on AniList
-func (c *AniListClient) SearchAnime(query string, page int, perPage int) ([]models.AnimeCache, int, error) {
+func (c *AniListClient) SearchAnime(ctx context.Context, query string, page int, perPage int) ([]models.AnimeCache, int, error) {
 	gqlQuery := `
     query ($search: String, $page: Int, $perPage: Int) {
         Page(page: $page, perPage: $perPage) {
-            pageInfo {
-                total
-                currentPage
-                lastPage
-                hasNextPage
-            }
-            media(search: $search, type: ANIME, sort: POPULARITY_DESC) {
-                id
-                title {
-                    romaji
-                    english
-                    native
-                }
-                coverImage {
-                    large
-                    medium
-                }
-                format
-                episodes
-            }
+            pageInfo { total currentPage lastPage hasNextPage }
+            media(search: $search, type: ANIME, sort: POPULARITY_DESC) {` + mediaSummaryFields + `}
         }
     }
     `
@@ -133,72 +278,58 @@ func (c *AniListClient) SearchAnime(query string, page int, perPage int) ([]mode
 		"page":    page,
 		"perPage": perPage,
 	}
+	return c.executePagedMediaQuery(ctx, gqlQuery, variables)
+}
 
-	response, err := c.executeQuery(gqlQuery, variables)
+// executeQuery handles the execution of GraphQL queries to AniList
+func (c *AniListClient) executeQuery(ctx context.Context, query string, variables map[string]interface{}) ([]byte, error) {
+	// Prepare the request body; map keys are sorted, so identical queries have identical bodies
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	var result struct {
-		Data struct {
-			Page struct {
-				PageInfo struct {
-					Total int `json:"total"`
-				} `json:"pageInfo"`
-				Media []struct {
-					ID    int `json:"id"`
-					Title struct {
-						Romaji  string `json:"romaji"`
-						English string `json:"english"`
-					} `json:"title"`
-					CoverImage struct {
-						Large string `json:"large"`
-					} `json:"coverImage"`
-					Format   string `json:"format"`
-					Episodes int    `json:"episodes"`
-				} `json:"media"`
-			} `json:"Page"`
-		} `json:"data"`
-	}
+	return c.coalesce(ctx, reqBody)
+}
 
-	if err := json.Unmarshal(response, &result); err != nil {
-		return nil, 0, fmt.Errorf("failed to parse search results: %v", err)
-	}
+// executeWithRetries sends the request, retrying network errors, 429 and 5xx responses
+// until ctx is done or the retries are used up
+func (c *AniListClient) executeWithRetries(ctx context.Context, reqBody []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 
-	// Convert to AnimeCache objects
-	animes := make([]models.AnimeCache, len(result.Data.Page.Media))
-	for i, media := range result.Data.Page.Media {
-		title := media.Title.English
-		if title == "" {
-			title = media.Title.Romaji
+		body, err := c.post(ctx, reqBody)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(err) || attempt >= c.maxRetries {
+			return nil, err
 		}
 
-		episodes := media.Episodes
-		animes[i] = models.AnimeCache{
-			ID:            media.ID,
-			Title:         title,
-			CoverImage:    media.CoverImage.Large,
-			Format:        media.Format,
-			TotalEpisodes: &episodes,
+		delay := backoff(c.retryDelay, attempt)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = statusErr.RetryAfter
+		}
+		log.Printf("AniList request failed (attempt %d of %d), retrying in %s: %v", attempt+1, c.maxRetries+1, delay.Round(time.Millisecond), err)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
 		}
 	}
-
-	return animes, result.Data.Page.PageInfo.Total, nil
 }
 
-// executeQuery handles the execution of GraphQL queries to AniList
-func (c *AniListClient) executeQuery(query string, variables map[string]interface{}) ([]byte, error) {
-	// Prepare the request body
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"query":     query,
-		"variables": variables,
-	})
-	if err != nil {
-		return nil, err
-	}
-
+// post sends one attempt of a query
+func (c *AniListClient) post(ctx context.Context, reqBody []byte) ([]byte, error) {
 	// Create the request
-	req, err := http.NewRequest("POST", AniListURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +343,12 @@ func (c *AniListClient) executeQuery(query string, variables map[string]interfac
 	}
 	defer resp.Body.Close()
 
+	// Stop every goroutine, not just this one, once AniList says the limit is used up
+	now := time.Now()
+	if until := rateLimitPause(resp.Header, now); !until.IsZero() {
+		c.limiter.PauseUntil(until)
+	}
+
 	// Read the response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -220,15 +357,25 @@ func (c *AniListClient) executeQuery(query string, variables map[string]interfac
 
 	// Check for non-200 responses
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("anilist API returned status %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+			Errors:     decodeGraphQLErrors(body),
+		}
+	}
+
+	// GraphQL reports failed queries in the body, even with a 200
+	if errs := decodeGraphQLErrors(body); errs != nil {
+		return nil, errs
 	}
 
 	return body, nil
 }
 
-// Helper function to execute paged media queries
-func (c *AniListClient) executePagedMediaQuery(query string, variables map[string]interface{}) ([]models.AnimeCache, int, error) {
-	response, err := c.executeQuery(query, variables)
+// Helper function to execute paged media queries selecting mediaSummaryFields
+func (c *AniListClient) executePagedMediaQuery(ctx context.Context, query string, variables map[string]interface{}) ([]models.AnimeCache, int, error) {
+	response, err := c.executeQuery(ctx, query, variables)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -239,18 +386,7 @@ func (c *AniListClient) executePagedMediaQuery(query string, variables map[strin
 				PageInfo struct {
 					Total int `json:"total"`
 				} `json:"pageInfo"`
-				Media []struct {
-					ID    int `json:"id"`
-					Title struct {
-						Romaji  string `json:"romaji"`
-						English string `json:"english"`
-					} `json:"title"`
-					CoverImage struct {
-						Large string `json:"large"`
-					} `json:"coverImage"`
-					Format   string `json:"format"`
-					Episodes *int   `json:"episodes"` // Use pointer for nullable
-				} `json:"media"`
+				Media []models.AnimeDetails `json:"media"`
 			} `json:"Page"`
 		} `json:"data"`
 	}
@@ -262,80 +398,51 @@ func (c *AniListClient) executePagedMediaQuery(query string, variables map[strin
 	// Convert to AnimeCache objects
 	animes := make([]models.AnimeCache, len(result.Data.Page.Media))
 	for i, media := range result.Data.Page.Media {
-		title := media.Title.English
-		if title == "" {
-			title = media.Title.Romaji
-		}
-
-		animes[i] = models.AnimeCache{
-			ID:            media.ID,
-			Title:         title,
-			CoverImage:    media.CoverImage.Large,
-			Format:        media.Format,
-			TotalEpisodes: media.Episodes, // Assign pointer directly
-		}
+		animes[i] = media.ToAnimeCache()
 	}
 
 	return animes, result.Data.Page.PageInfo.Total, nil
 }
 
 // GetPopularAnime fetches popular anime
-func (c *AniListClient) GetPopularAnime(page int, perPage int) ([]models.AnimeCache, int, error) {
+func (c *AniListClient) GetPopularAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error) {
 	gqlQuery := `
     query ($page: Int, $perPage: Int) {
         Page(page: $page, perPage: $perPage) {
             pageInfo { total currentPage lastPage hasNextPage }
-            media(type: ANIME, sort: POPULARITY_DESC) {
-                id
-                title { romaji english }
-                coverImage { large }
-                format
-                episodes
-            }
+            media(type: ANIME, sort: POPULARITY_DESC) {` + mediaSummaryFields + `}
         }
     }`
 	variables := map[string]interface{}{
 		"page":    page,
 		"perPage": perPage,
 	}
-	return c.executePagedMediaQuery(gqlQuery, variables)
+	return c.executePagedMediaQuery(ctx, gqlQuery, variables)
 }
 
 // GetTrendingAnime fetches trending anime
-func (c *AniListClient) GetTrendingAnime(page int, perPage int) ([]models.AnimeCache, int, error) {
+func (c *AniListClient) GetTrendingAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error) {
 	gqlQuery := `
     query ($page: Int, $perPage: Int) {
         Page(page: $page, perPage: $perPage) {
             pageInfo { total currentPage lastPage hasNextPage }
-            media(type: ANIME, sort: TRENDING_DESC) {
-                id
-                title { romaji english }
-                coverImage { large }
-                format
-                episodes
-            }
+            media(type: ANIME, sort: TRENDING_DESC) {` + mediaSummaryFields + `}
         }
     }`
 	variables := map[string]interface{}{
 		"page":    page,
 		"perPage": perPage,
 	}
-	return c.executePagedMediaQuery(gqlQuery, variables)
+	return c.executePagedMediaQuery(ctx, gqlQuery, variables)
 }
 
 // GetAnimeBySeason fetches anime by year and season
-func (c *AniListClient) GetAnimeBySeason(year int, season string, page int, perPage int) ([]models.AnimeCache, int, error) {
+func (c *AniListClient) GetAnimeBySeason(ctx context.Context, year int, season string, page int, perPage int) ([]models.AnimeCache, int, error) {
 	gqlQuery := `
     query ($page: Int, $perPage: Int, $season: MediaSeason, $seasonYear: Int) {
         Page(page: $page, perPage: $perPage) {
             pageInfo { total currentPage lastPage hasNextPage }
-            media(type: ANIME, season: $season, seasonYear: $seasonYear, sort: POPULARITY_DESC) {
-                id
-                title { romaji english }
-                coverImage { large }
-                format
-                episodes
-            }
+            media(type: ANIME, season: $season, seasonYear: $seasonYear, sort: POPULARITY_DESC) {` + mediaSummaryFields + `}
         }
     }`
 	variables := map[string]interface{}{
@@ -344,5 +451,5 @@ func (c *AniListClient) GetAnimeBySeason(year int, season string, page int, perP
 		"season":     season, // WINTER, SPRING, SUMMER, FALL
 		"seasonYear": year,
 	}
-	return c.executePagedMediaQuery(gqlQuery, variables)
+	return c.executePagedMediaQuery(ctx, gqlQuery, variables)
 }
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/ratelimit"
+)
+
+func newTestClient(url string, maxRetries int) *AniListClient {
+	return NewAniListClient(config.AniListSettings{
+		GraphQLURL:     url,
+		Timeout:        time.Second,
+		MaxRetries:     maxRetries,
+		RetryBaseDelay: time.Millisecond,
+		RateLimit:      ratelimit.PerMinute(1000),
+	})
+}
+
+// Test 5xx responses are retried until one succeeds
+func TestExecuteQueryRetriesServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"data":{"Media":{"id":1}}}`))
+	}))
+	defer server.Close()
+
+	body, err := newTestClient(server.URL, 3).executeQuery(context.Background(), "query", nil)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"data":{"Media":{"id":1}}}`, string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+// Test client errors are returned at once and rate limiting is reported as ErrRateLimited
+func TestExecuteQueryDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	status := http.StatusBadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, 3).executeQuery(context.Background(), "query", nil)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	status = http.StatusTooManyRequests
+	_, err = newTestClient(server.URL, 1).executeQuery(context.Background(), "query", nil)
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+// Test a Retry-After header pauses the request and a cancelled context ends the wait
+func TestExecuteQueryHonorsRetryAfterAndContext(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := newTestClient(server.URL, 3).executeQuery(ctx, "query", nil)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// Test an exhausted X-RateLimit-Remaining pauses the whole client
+func TestRateLimitPause(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "12")
+	assert.True(t, rateLimitPause(header, now).IsZero())
+
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", "1700000042")
+	assert.Equal(t, now.Add(42*time.Second), rateLimitPause(header, now))
+
+	header.Set("Retry-After", "7")
+	assert.Equal(t, now.Add(7*time.Second), rateLimitPause(header, now))
+}
+
+// Test GraphQL errors are decoded, and missing media is reported as ErrNotFound
+func TestGraphQLErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/invalid" {
+			w.Write([]byte(`{"errors":[{"message":"Syntax Error","status":400,"locations":[{"line":1,"column":2}]}],"data":null}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[{"message":"Not Found.","status":404}],"data":{"Media":null}}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, 3).GetAnimeByID(context.Background(), 999999)
+	assert.ErrorIs(t, err, ErrNotFound)
+	var graphQLErrs GraphQLErrors
+	assert.True(t, errors.As(err, &graphQLErrs))
+	assert.Equal(t, "Not Found.", graphQLErrs[0].Message)
+
+	// A 200 with errors is not retried
+	_, err = newTestClient(server.URL+"/invalid", 3).executeQuery(context.Background(), "query", nil)
+	assert.True(t, errors.As(err, &graphQLErrs))
+	assert.False(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 1, graphQLErrs[0].Locations[0].Line)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+// Test concurrent identical queries share one upstream request
+func TestExecuteQueryCoalescesIdenticalQueries(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(`{"data":{"Media":{"id":1}}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, 0)
+	const callers = 10
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			_, err := client.GetAnimeByID(context.Background(), 1)
+			errs <- err
+		}()
+	}
+
+	// Wait until every caller joined the call in flight
+	for client.Stats().Queries < callers {
+		time.Sleep(time.Millisecond)
+	}
+	// Counted just before joining, give the last ones a moment to join
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < callers; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, QueryStats{Queries: callers, Upstream: 1, Deduplicated: callers - 1}, client.Stats())
+
+	// A different query is not coalesced with it
+	_, err := client.GetAnimeByID(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+// Test the upstream request is aborted once its only waiter stops waiting
+func TestExecuteQueryCancelsAbandonedCall(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices the client going away only once the body is read
+		io.Copy(io.Discard, r.Body)
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.GetAnimeByID(ctx, 1)
+		errs <- err
+	}()
+
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-aborted:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+// Test only GetAnimeDetailsByID asks for the extended sections
+func TestGetAnimeByIDLeavesOutExtendedSections(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		queries = append(queries, body.Query)
+		w.Write([]byte(`{"data":{"Media":{"id":1}}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL, 0)
+	_, err := client.GetAnimeByID(context.Background(), 1)
+	assert.NoError(t, err)
+	_, err = client.GetAnimeDetailsByID(context.Background(), 1)
+	assert.NoError(t, err)
+
+	if assert.Len(t, queries, 2) {
+		assert.NotContains(t, queries[0], "characters")
+		assert.Contains(t, queries[0], "nextAiringEpisode")
+		assert.Contains(t, queries[1], "characters")
+	}
+}
+
+// Test GetAnimeByIDs asks for at most 50 IDs per request
+func TestGetAnimeByIDsChunks(t *testing.T) {
+	var chunkSizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Variables struct {
+				IDs []int `json:"ids"`
+			} `json:"variables"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		chunkSizes = append(chunkSizes, len(request.Variables.IDs))
+
+		media := make([]map[string]int, len(request.Variables.IDs))
+		for i, id := range request.Variables.IDs {
+			media[i] = map[string]int{"id": id}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"Page": map[string]interface{}{"media": media}},
+		})
+	}))
+	defer server.Close()
+
+	ids := make([]int, 120)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	animes, err := newTestClient(server.URL, 0).GetAnimeByIDs(context.Background(), ids)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{50, 50, 20}, chunkSizes)
+	assert.Len(t, animes, 120)
+	assert.Equal(t, 120, animes[119].ID)
+}
+
+// Test the filter becomes media() arguments, narrowed to the studio's anime
+func TestBrowseAnime(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request.Variables)
+
+		if _, ok := request.Variables["search"]; ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"Studio": map[string]interface{}{"media": map[string]interface{}{
+					"pageInfo": map[string]bool{"hasNextPage": false},
+					"nodes":    []map[string]int{{"id": 5114}, {"id": 16498}},
+				}}},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"Page": map[string]interface{}{
+				"pageInfo": map[string]int{"total": 1},
+				"media":    []map[string]interface{}{{"id": 16498, "title": map[string]string{"romaji": "Shingeki no Kyojin"}}},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	result, err := newTestClient(server.URL, 0).BrowseAnime(context.Background(), BrowseFilter{
+		Genres:      []string{"Action"},
+		Formats:     []string{"TV"},
+		YearFrom:    2010,
+		YearTo:      2015,
+		MinScore:    80,
+		MaxEpisodes: 26,
+		Studio:      "Wit Studio",
+	}, 1, 20)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
+	assert.False(t, result.Truncated)
+	assert.Equal(t, "Shingeki no Kyojin", result.Anime[0].Title)
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, "Wit Studio", requests[0]["search"])
+		browse := requests[1]
+		assert.Equal(t, []interface{}{5114.0, 16498.0}, browse["ids"])
+		assert.Equal(t, []interface{}{"Action"}, browse["genres"])
+		assert.Equal(t, []interface{}{"TV"}, browse["formats"])
+		assert.Equal(t, 20099999.0, browse["startedAfter"])
+		assert.Equal(t, 20160000.0, browse["startedBefore"])
+		assert.Equal(t, 79.0, browse["scoreAbove"])
+		assert.Equal(t, 27.0, browse["episodesBelow"])
+		assert.Equal(t, false, browse["isAdult"])
+		assert.Equal(t, []interface{}{"POPULARITY_DESC", "ID"}, browse["sort"])
+		assert.NotContains(t, browse, "episodesAbove")
+	}
+}
+
+// Test a studio with more anime than are searched is reported as truncated
+func TestBrowseAnimeTruncatesStudio(t *testing.T) {
+	var studioPages int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		if _, ok := request.Variables["search"]; ok {
+			page := int(atomic.AddInt32(&studioPages, 1))
+			nodes := make([]map[string]int, maxPerPage)
+			for i := range nodes {
+				nodes[i] = map[string]int{"id": page*maxPerPage + i}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"Studio": map[string]interface{}{"media": map[string]interface{}{
+					"pageInfo": map[string]bool{"hasNextPage": true},
+					"nodes":    nodes,
+				}}},
+			})
+			return
+		}
+		assert.Len(t, request.Variables["ids"], maxStudioMedia)
+		w.Write([]byte(`{"data":{"Page":{"pageInfo":{"total":0},"media":[]}}}`))
+	}))
+	defer server.Close()
+
+	result, err := newTestClient(server.URL, 0).BrowseAnime(context.Background(), BrowseFilter{Studio: "Toei Animation"}, 1, 20)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Truncated)
+	assert.Equal(t, int32(maxStudioMedia/maxPerPage), atomic.LoadInt32(&studioPages))
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// Values AniList accepts for the format, status and sort arguments of media()
+var (
+	MediaFormats  = []string{"TV", "TV_SHORT", "MOVIE", "SPECIAL", "OVA", "ONA", "MUSIC"}
+	MediaStatuses = []string{"FINISHED", "RELEASING", "NOT_YET_RELEASED", "CANCELLED", "HIATUS"}
+	MediaSorts    = []string{"POPULARITY_DESC", "TRENDING_DESC", "SCORE_DESC", "FAVOURITES_DESC", "START_DATE_DESC", "START_DATE", "TITLE_ROMAJI", "EPISODES_DESC"}
+)
+
+// maxStudioMedia caps how many anime of a studio a browse query is narrowed down to
+const maxStudioMedia = 200
+
+// BrowseFilter selects and orders anime for BrowseAnime. Zero values do not filter.
+// The caller validates it; the values are passed to AniList as they are.
+type BrowseFilter struct {
+	Genres        []string // All of these genres
+	ExcludeGenres []string // None of these genres
+	Tags          []string // All of these tags, e.g. "Time Skip"
+	Formats       []string // Any of MediaFormats
+	Statuses      []string // Any of MediaStatuses
+	YearFrom      int      // Started in or after this year
+	YearTo        int      // Started in or before this year
+	MinScore      int      // Average score of at least this, 0-100
+	MinEpisodes   int
+	MaxEpisodes   int
+	Studio        string // Main studio, matched by AniList's studio search
+	IncludeAdult  bool   // Adult anime are left out unless set
+	Sort          string // One of MediaSorts, POPULARITY_DESC when empty
+}
+
+// variables translates the filter into the arguments of media(). AniList's
+// _greater and _lesser arguments are exclusive, so the bounds are widened by one;
+// start dates are compared as YYYYMMDD integers, and a start date only known by
+// its year is YYYY0000.
+func (f BrowseFilter) variables() map[string]interface{} {
+	sort := f.Sort
+	if sort == "" {
+		sort = "POPULARITY_DESC"
+	}
+	variables := map[string]interface{}{
+		"sort": []string{sort, "ID"},
+	}
+	if !f.IncludeAdult {
+		variables["isAdult"] = false
+	}
+	if len(f.Genres) > 0 {
+		variables["genres"] = f.Genres
+	}
+	if len(f.ExcludeGenres) > 0 {
+		variables["excludeGenres"] = f.ExcludeGenres
+	}
+	if len(f.Tags) > 0 {
+		variables["tags"] = f.Tags
+	}
+	if len(f.Formats) > 0 {
+		variables["formats"] = f.Formats
+	}
+	if len(f.Statuses) > 0 {
+		variables["statuses"] = f.Statuses
+	}
+	if f.YearFrom > 0 {
+		variables["startedAfter"] = f.YearFrom*10000 - 1
+	}
+	if f.YearTo > 0 {
+		variables["startedBefore"] = (f.YearTo + 1) * 10000
+	}
+	if f.MinScore > 0 {
+		variables["scoreAbove"] = f.MinScore - 1
+	}
+	if f.MinEpisodes > 0 {
+		variables["episodesAbove"] = f.MinEpisodes - 1
+	}
+	if f.MaxEpisodes > 0 {
+		variables["episodesBelow"] = f.MaxEpisodes + 1
+	}
+	return variables
+}
+
+// BrowseResult is a page of BrowseAnime
+type BrowseResult struct {
+	Anime []models.AnimeCache
+	Total int
+	// Truncated is set when the studio has more than maxStudioMedia anime and only those were searched
+	Truncated bool
+}
+
+// BrowseAnime lists anime matching the filter. AniList cannot filter media() by studio,
+// so a studio filter first looks up the studio's anime and narrows the query to their IDs.
+func (c *AniListClient) BrowseAnime(ctx context.Context, filter BrowseFilter, page int, perPage int) (BrowseResult, error) {
+	gqlQuery := `
+    query ($page: Int, $perPage: Int, $sort: [MediaSort], $isAdult: Boolean, $ids: [Int],
+           $genres: [String], $excludeGenres: [String], $tags: [String], $formats: [MediaFormat], $statuses: [MediaStatus],
+           $startedAfter: FuzzyDateInt, $startedBefore: FuzzyDateInt, $scoreAbove: Int, $episodesAbove: Int, $episodesBelow: Int) {
+        Page(page: $page, perPage: $perPage) {
+            pageInfo { total currentPage lastPage hasNextPage }
+            media(type: ANIME, sort: $sort, isAdult: $isAdult, id_in: $ids,
+                  genre_in: $genres, genre_not_in: $excludeGenres, tag_in: $tags, format_in: $formats, status_in: $statuses,
+                  startDate_greater: $startedAfter, startDate_lesser: $startedBefore, averageScore_greater: $scoreAbove,
+                  episodes_greater: $episodesAbove, episodes_lesser: $episodesBelow) {` + mediaSummaryFields + `}
+        }
+    }`
+
+	variables := filter.variables()
+	variables["page"] = page
+	variables["perPage"] = perPage
+
+	var result BrowseResult
+	if filter.Studio != "" {
+		ids, truncated, err := c.studioMediaIDs(ctx, filter.Studio)
+		if err != nil {
+			return result, err
+		}
+		if len(ids) == 0 {
+			result.Anime = []models.AnimeCache{}
+			return result, nil
+		}
+		variables["ids"] = ids
+		result.Truncated = truncated
+	}
+
+	var err error
+	result.Anime, result.Total, err = c.executePagedMediaQuery(ctx, gqlQuery, variables)
+	return result, err
+}
+
+// studioMediaIDs returns the IDs of up to maxStudioMedia anime the best match for
+// the studio search produced, or none when no studio matches. It reports whether
+// the studio has more anime than that.
+func (c *AniListClient) studioMediaIDs(ctx context.Context, studio string) ([]int, bool, error) {
+	query := `
+    query ($search: String, $page: Int, $perPage: Int) {
+        Studio(search: $search) {
+            media(isMain: true, page: $page, perPage: $perPage) {
+                pageInfo { hasNextPage }
+                nodes { id }
+            }
+        }
+    }`
+
+	ids := []int{}
+	hasMore := false
+	for page := 1; len(ids) < maxStudioMedia; page++ {
+		variables := map[string]interface{}{
+			"search":  studio,
+			"page":    page,
+			"perPage": maxPerPage,
+		}
+		response, err := c.executeQuery(ctx, query, variables)
+		if errors.Is(err, ErrNotFound) {
+			return ids, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to look up studio: %w", err)
+		}
+
+		var result struct {
+			Data struct {
+				Studio *struct {
+					Media struct {
+						PageInfo struct {
+							HasNextPage bool `json:"hasNextPage"`
+						} `json:"pageInfo"`
+						Nodes []struct {
+							ID int `json:"id"`
+						} `json:"nodes"`
+					} `json:"media"`
+				} `json:"Studio"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(response, &result); err != nil {
+			return nil, false, fmt.Errorf("failed to parse studio data: %v", err)
+		}
+		if result.Data.Studio == nil {
+			return ids, false, nil
+		}
+
+		for _, node := range result.Data.Studio.Media.Nodes {
+			ids = append(ids, node.ID)
+		}
+		hasMore = result.Data.Studio.Media.PageInfo.HasNextPage
+		if !hasMore {
+			break
+		}
+	}
+	return ids[:min(len(ids), maxStudioMedia)], hasMore || len(ids) > maxStudioMedia, nil
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sharedCallTimeout bounds a coalesced upstream call. The call is detached from the
+// context of the caller that started it, as other callers may still be waiting for it;
+// it is cancelled once no caller waits for it anymore.
+const sharedCallTimeout = time.Minute
+
+// QueryStats counts how the client's queries were served
+type QueryStats struct {
+	Queries      int64 `json:"queries"`           // Queries asked for by callers
+	Upstream     int64 `json:"upstream_requests"` // Queries actually sent to AniList, retries not counted
+	Deduplicated int64 `json:"deduplicated"`      // Queries that shared the result of an identical one in flight
+}
+
+// StatsReporter is implemented by clients that keep QueryStats
+type StatsReporter interface {
+	Stats() QueryStats
+}
+
+// Ensure the real client reports its stats
+var _ StatsReporter = (*AniListClient)(nil)
+
+// queryStats holds the counters behind QueryStats
+type queryStats struct {
+	queries      atomic.Int64
+	upstream     atomic.Int64
+	deduplicated atomic.Int64
+}
+
+// Stats returns the counters since the client was created
+func (c *AniListClient) Stats() QueryStats {
+	return QueryStats{
+		Queries:      c.stats.queries.Load(),
+		Upstream:     c.stats.upstream.Load(),
+		Deduplicated: c.stats.deduplicated.Load(),
+	}
+}
+
+// sharedCall is an upstream request in flight and the callers waiting for it
+type sharedCall struct {
+	done    chan struct{} // Closed once val and err are set
+	val     []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// sharedCalls are the calls in flight, by request body
+type sharedCalls struct {
+	mu    sync.Mutex
+	calls map[string]*sharedCall
+}
+
+// coalesce runs the request once for all concurrent callers with the same body,
+// i.e. the same query and variables. Each caller stops waiting when its own ctx is done,
+// and the request is cancelled when the last one does.
+func (c *AniListClient) coalesce(ctx context.Context, reqBody []byte) ([]byte, error) {
+	c.stats.queries.Add(1)
+	key := string(reqBody)
+
+	c.calls.mu.Lock()
+	call, found := c.calls.calls[key]
+	if found {
+		call.waiters++
+		c.calls.mu.Unlock()
+		c.stats.deduplicated.Add(1)
+	} else {
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedCallTimeout)
+		call = &sharedCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		if c.calls.calls == nil {
+			c.calls.calls = make(map[string]*sharedCall)
+		}
+		c.calls.calls[key] = call
+		c.calls.mu.Unlock()
+		c.stats.upstream.Add(1)
+
+		go func() {
+			defer cancel()
+			call.val, call.err = c.executeWithRetries(callCtx, reqBody)
+			c.calls.forget(key, call)
+			close(call.done)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		c.calls.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			call.cancel()
+			c.calls.forgetLocked(key, call)
+		}
+		c.calls.mu.Unlock()
+		return nil, ctx.Err()
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		// Callers share the slice; none of them modifies it
+		return call.val, nil
+	}
+}
+
+// forget removes the call so later callers start a new one
+func (s *sharedCalls) forget(key string, call *sharedCall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forgetLocked(key, call)
+}
+
+func (s *sharedCalls) forgetLocked(key string, call *sharedCall) {
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is matched by errors.Is when AniList has no media with the requested ID
+	ErrNotFound = errors.New("anilist: not found")
+	// ErrRateLimited is matched by errors.Is when AniList kept answering 429 after all retries
+	ErrRateLimited = errors.New("anilist: rate limit reached")
+)
+
+// GraphQLError is one entry of the "errors" array of a GraphQL response
+type GraphQLError struct {
+	Message   string `json:"message"`
+	Status    int    `json:"status"` // AniList adds the matching HTTP status, e.g. 404 for missing media
+	Locations []struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"locations"`
+}
+
+func (e GraphQLError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("%s (status %d)", e.Message, e.Status)
+	}
+	return e.Message
+}
+
+// GraphQLErrors are all errors of one response
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "anilist GraphQL errors: " + strings.Join(messages, "; ")
+}
+
+// Is makes errors.Is(err, ErrNotFound) true when any of the errors is a 404
+func (e GraphQLErrors) Is(target error) bool {
+	if target != ErrNotFound {
+		return false
+	}
+	for _, err := range e {
+		if err.Status == http.StatusNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeGraphQLErrors returns the "errors" array of a response body, nil if there is none
+func decodeGraphQLErrors(body []byte) GraphQLErrors {
+	var response struct {
+		Errors GraphQLErrors `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Errors) == 0 {
+		return nil
+	}
+	return response.Errors
+}
+
+// StatusError is a non-200 response from AniList
+type StatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header, zero if absent
+	Errors     GraphQLErrors // Decoded from the body, if it had any
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Errors) > 0 {
+		return fmt.Sprintf("anilist API returned status %d: %s", e.StatusCode, e.Errors.Error())
+	}
+	return fmt.Sprintf("anilist API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Is makes errors.Is(err, ErrRateLimited) true for 429 responses and errors.Is(err, ErrNotFound) for 404s
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Unwrap exposes the GraphQL errors to errors.As
+func (e *StatusError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFranchiseDepth is how many relation steps away from the requested anime are followed
+	maxFranchiseDepth = 10
+	// maxFranchiseNodes stops the traversal of very large franchises
+	maxFranchiseNodes = 150
+	// maxCachedFranchises bounds the franchise cache, by anime ID
+	maxCachedFranchises = 5000
+)
+
+// Relation types in a Franchise. AniList reports each relation from both sides;
+// the franchise only keeps the forward one: PREQUEL becomes SEQUEL and PARENT becomes SIDE_STORY.
+const (
+	RelationSequel    = "SEQUEL"
+	RelationSideStory = "SIDE_STORY"
+)
+
+// FuzzyDate is a date AniList may only know the year or month of; unknown parts are 0
+type FuzzyDate struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Day   int `json:"day"`
+}
+
+// compare orders known dates chronologically and unknown dates last
+func (d FuzzyDate) compare(other FuzzyDate) int {
+	if (d.Year == 0) != (other.Year == 0) {
+		if d.Year == 0 {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Or(cmp.Compare(d.Year, other.Year), cmp.Compare(d.Month, other.Month), cmp.Compare(d.Day, other.Day))
+}
+
+// FranchiseNode is one anime of a franchise
+type FranchiseNode struct {
+	ID         int       `json:"id"`
+	Title      string    `json:"title"`
+	Format     string    `json:"format"`
+	Status     string    `json:"status"`
+	Episodes   int       `json:"episodes,omitempty"`
+	StartDate  FuzzyDate `json:"start_date"`
+	CoverImage string    `json:"cover_image"`
+	Depth      int       `json:"depth"` // Relation steps from the requested anime
+}
+
+// FranchiseEdge relates two anime of a franchise: To is a sequel or side story of From
+type FranchiseEdge struct {
+	From         int    `json:"from"`
+	To           int    `json:"to"`
+	RelationType string `json:"relation_type"`
+}
+
+// Franchise is the graph of anime connected to one anime by sequel, prequel,
+// side story and parent relations, with the orders to watch them in.
+// Franchises are cached and shared, callers must not change them.
+type Franchise struct {
+	RootID int
+	Nodes  []FranchiseNode // In release order
+	Edges  []FranchiseEdge
+	// Truncated is set when the depth or size limit left out parts of the franchise
+	Truncated bool
+
+	ReleaseOrder       []int // Anime IDs by start date
+	ChronologicalOrder []int // Anime IDs in story order
+}
+
+// franchiseRelations are the AniList relation types followed, with the forward type
+// and whether the related anime comes before the one it is related to
+var franchiseRelations = map[string]struct {
+	relationType string
+	before       bool
+}{
+	"SEQUEL":     {RelationSequel, false},
+	"PREQUEL":    {RelationSequel, true},
+	"SIDE_STORY": {RelationSideStory, false},
+	"PARENT":     {RelationSideStory, true},
+}
+
+// franchiseMedia is the part of a Media object the traversal needs
+type franchiseMedia struct {
+	ID    int `json:"id"`
+	Title struct {
+		Romaji  string `json:"romaji"`
+		English string `json:"english"`
+	} `json:"title"`
+	Format     string    `json:"format"`
+	Status     string    `json:"status"`
+	Episodes   int       `json:"episodes"`
+	StartDate  FuzzyDate `json:"startDate"`
+	CoverImage struct {
+		Large string `json:"large"`
+	} `json:"coverImage"`
+	Relations struct {
+		Edges []struct {
+			RelationType string `json:"relationType"`
+			Node         struct {
+				ID   int    `json:"id"`
+				Type string `json:"type"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"relations"`
+}
+
+type franchiseEntry struct {
+	franchise *Franchise
+	expiresAt time.Time
+}
+
+// franchiseCache keeps complete franchises under the ID of every anime in them,
+// so the graph is walked once for all of them
+type franchiseCache struct {
+	mu      sync.Mutex
+	entries map[int]franchiseEntry
+}
+
+func (c *franchiseCache) get(id int, now time.Time) (*Franchise, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || !now.Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.franchise, true
+}
+
+func (c *franchiseCache) set(ids []int, franchise *Franchise, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries)+len(ids) > maxCachedFranchises {
+		c.entries = make(map[int]franchiseEntry)
+	}
+	for _, id := range ids {
+		c.entries[id] = franchiseEntry{franchise: franchise, expiresAt: expiresAt}
+	}
+}
+
+// GetFranchise walks the relations of the anime breadth first, one query per level,
+// up to maxFranchiseDepth steps and maxFranchiseNodes anime. Anime already seen are
+// not visited again, so relation cycles end the walk.
+func (c *AniListClient) GetFranchise(ctx context.Context, id int) (*Franchise, error) {
+	if franchise, ok := c.franchises.get(id, time.Now()); ok {
+		return franchise, nil
+	}
+
+	media := map[int]*franchiseMedia{}
+	depths := map[int]int{id: 0}
+	level := []int{id}
+	truncated := false
+	for depth := 0; len(level) > 0; depth++ {
+		fetched, err := c.franchiseMedia(ctx, level)
+		if err != nil {
+			return nil, err
+		}
+		if depth == 0 && len(fetched) == 0 {
+			return nil, ErrNotFound
+		}
+
+		var next []int
+		for i := range fetched {
+			m := &fetched[i]
+			media[m.ID] = m
+			for _, edge := range m.Relations.Edges {
+				if _, ok := franchiseRelations[edge.RelationType]; !ok || edge.Node.Type != "ANIME" {
+					continue
+				}
+				if _, seen := depths[edge.Node.ID]; seen {
+					continue
+				}
+				if depth+1 > maxFranchiseDepth || len(depths) >= maxFranchiseNodes {
+					truncated = true
+					continue
+				}
+				depths[edge.Node.ID] = depth + 1
+				next = append(next, edge.Node.ID)
+			}
+		}
+		level = next
+	}
+
+	franchise := buildFranchise(id, media, depths)
+	franchise.Truncated = truncated
+	if c.franchiseTTL > 0 {
+		// A truncated walk depends on where it started, it is only cached for that anime
+		ids := []int{id}
+		if !truncated {
+			ids = franchise.ReleaseOrder
+		}
+		c.franchises.set(ids, franchise, time.Now().Add(c.franchiseTTL))
+	}
+	return franchise, nil
+}
+
+// franchiseMedia fetches the media with their relations, in pages of maxPerPage IDs
+func (c *AniListClient) franchiseMedia(ctx context.Context, ids []int) ([]franchiseMedia, error) {
+	query := `
+    query ($ids: [Int], $perPage: Int) {
+        Page(page: 1, perPage: $perPage) {
+            media(id_in: $ids, type: ANIME) {
+                id
+                title { romaji english }
+                format
+                status
+                episodes
+                startDate { year month day }
+                coverImage { large }
+                relations {
+                    edges {
+                        relationType(version: 2)
+                        node { id type }
+                    }
+                }
+            }
+        }
+    }`
+
+	var media []franchiseMedia
+	for start := 0; start < len(ids); start += maxPerPage {
+		variables := map[string]interface{}{
+			"ids":     ids[start:min(start+maxPerPage, len(ids))],
+			"perPage": maxPerPage,
+		}
+		response, err := c.executeQuery(ctx, query, variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch franchise: %w", err)
+		}
+
+		var result struct {
+			Data struct {
+				Page struct {
+					Media []franchiseMedia `json:"media"`
+				} `json:"Page"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(response, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse franchise data: %v", err)
+		}
+		media = append(media, result.Data.Page.Media...)
+	}
+	return media, nil
+}
+
+// buildFranchise turns the fetched media into nodes and forward edges between them,
+// and computes the watch orders
+func buildFranchise(rootID int, media map[int]*franchiseMedia, depths map[int]int) *Franchise {
+	franchise := &Franchise{RootID: rootID, Nodes: []FranchiseNode{}, Edges: []FranchiseEdge{}}
+	for id, m := range media {
+		title := m.Title.English
+		if title == "" {
+			title = m.Title.Romaji
+		}
+		franchise.Nodes = append(franchise.Nodes, FranchiseNode{
+			ID:         id,
+			Title:      title,
+			Format:     m.Format,
+			Status:     m.Status,
+			Episodes:   m.Episodes,
+			StartDate:  m.StartDate,
+			CoverImage: m.CoverImage.Large,
+			Depth:      depths[id],
+		})
+	}
+	slices.SortFunc(franchise.Nodes, func(a, b FranchiseNode) int {
+		return cmp.Or(a.StartDate.compare(b.StartDate), cmp.Compare(a.ID, b.ID))
+	})
+	franchise.ReleaseOrder = make([]int, len(franchise.Nodes))
+	for i, node := range franchise.Nodes {
+		franchise.ReleaseOrder[i] = node.ID
+	}
+
+	// Both sides of a relation are reported, keep each edge once
+	seen := map[FranchiseEdge]bool{}
+	for _, m := range media {
+		for _, edge := range m.Relations.Edges {
+			relation, ok := franchiseRelations[edge.RelationType]
+			if _, known := media[edge.Node.ID]; !ok || !known {
+				continue
+			}
+			e := FranchiseEdge{From: m.ID, To: edge.Node.ID, RelationType: relation.relationType}
+			if relation.before {
+				e.From, e.To = e.To, e.From
+			}
+			if e.From != e.To && !seen[e] {
+				seen[e] = true
+				franchise.Edges = append(franchise.Edges, e)
+			}
+		}
+	}
+	slices.SortFunc(franchise.Edges, func(a, b FranchiseEdge) int {
+		return cmp.Or(cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To), cmp.Compare(a.RelationType, b.RelationType))
+	})
+
+	franchise.ChronologicalOrder = chronologicalOrder(franchise.ReleaseOrder, franchise.Edges)
+	return franchise
+}
+
+// chronologicalOrder sorts the anime so every anime comes after its prequels and
+// the anime it is a side story of, taking the earliest released anime whenever
+// there is a choice. Anime in a relation cycle are appended in release order.
+func chronologicalOrder(releaseOrder []int, edges []FranchiseEdge) []int {
+	position := make(map[int]int, len(releaseOrder))
+	for i, id := range releaseOrder {
+		position[id] = i
+	}
+	before := make(map[int]int, len(releaseOrder)) // Number of anime that must come first
+	after := map[int][]int{}
+	for _, edge := range edges {
+		before[edge.To]++
+		after[edge.From] = append(after[edge.From], edge.To)
+	}
+
+	// ready is kept in release order; franchises are small, so a sorted slice will do
+	var ready []int
+	for _, id := range releaseOrder {
+		if before[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	order := make([]int, 0, len(releaseOrder))
+	done := make(map[int]bool, len(releaseOrder))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		done[id] = true
+		for _, next := range after[id] {
+			if before[next]--; before[next] == 0 {
+				i, _ := slices.BinarySearchFunc(ready, next, func(a, b int) int {
+					return cmp.Compare(position[a], position[b])
+				})
+				ready = slices.Insert(ready, i, next)
+			}
+		}
+	}
+
+	for _, id := range releaseOrder {
+		if !done[id] {
+			order = append(order, id)
+		}
+	}
+	return order
+}
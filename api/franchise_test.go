@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// franchiseServer answers franchise queries from a fixed set of media
+func franchiseServer(t *testing.T, media map[int]map[string]interface{}, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		var request struct {
+			Variables struct {
+				IDs []int `json:"ids"`
+			} `json:"variables"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		page := []map[string]interface{}{}
+		for _, id := range request.Variables.IDs {
+			if m, ok := media[id]; ok {
+				page = append(page, m)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"Page": map[string]interface{}{"media": page}},
+		})
+	}))
+}
+
+func franchiseMediaFixture(id int, year int, relations ...[2]interface{}) map[string]interface{} {
+	edges := []map[string]interface{}{}
+	for _, relation := range relations {
+		mediaType := "ANIME"
+		if relation[0] == "ADAPTATION" {
+			mediaType = "MANGA"
+		}
+		edges = append(edges, map[string]interface{}{
+			"relationType": relation[0],
+			"node":         map[string]interface{}{"id": relation[1], "type": mediaType},
+		})
+	}
+	return map[string]interface{}{
+		"id":        id,
+		"title":     map[string]string{"romaji": "Anime " + string(rune('A'+id-1))},
+		"startDate": map[string]int{"year": year},
+		"relations": map[string]interface{}{"edges": edges},
+	}
+}
+
+// Test the walk follows both directions of sequel and side story relations, stops at
+// anime it has seen and orders a prequel released later first in the story
+func TestGetFranchise(t *testing.T) {
+	media := map[int]map[string]interface{}{
+		1: franchiseMediaFixture(1, 2013, [2]interface{}{"SEQUEL", 2}, [2]interface{}{"PREQUEL", 5}, [2]interface{}{"ADAPTATION", 100}),
+		2: franchiseMediaFixture(2, 2017, [2]interface{}{"PREQUEL", 1}, [2]interface{}{"SEQUEL", 3}, [2]interface{}{"SIDE_STORY", 4}),
+		3: franchiseMediaFixture(3, 2018, [2]interface{}{"PREQUEL", 2}),
+		4: franchiseMediaFixture(4, 2019, [2]interface{}{"PARENT", 2}, [2]interface{}{"CHARACTER", 9}),
+		5: franchiseMediaFixture(5, 2020, [2]interface{}{"SEQUEL", 1}),
+	}
+	var calls int32
+	server := franchiseServer(t, media, &calls)
+	defer server.Close()
+
+	client := newTestClient(server.URL, 0)
+	client.franchiseTTL = time.Hour
+	franchise, err := client.GetFranchise(context.Background(), 3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, franchise.RootID)
+	assert.False(t, franchise.Truncated)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, franchise.ReleaseOrder)
+	assert.Equal(t, []int{5, 1, 2, 3, 4}, franchise.ChronologicalOrder)
+	assert.Equal(t, []FranchiseEdge{
+		{From: 1, To: 2, RelationType: RelationSequel},
+		{From: 2, To: 3, RelationType: RelationSequel},
+		{From: 2, To: 4, RelationType: RelationSideStory},
+		{From: 5, To: 1, RelationType: RelationSequel},
+	}, franchise.Edges)
+	assert.Equal(t, "Anime A", franchise.Nodes[0].Title)
+	assert.Equal(t, 2, franchise.Nodes[0].Depth) // 3 -> 2 -> 1
+	// One query per level: 3, then 2, then 1 and 4, then 5
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	// Every anime of the franchise is answered from the cache
+	cached, err := client.GetFranchise(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Same(t, franchise, cached)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+// Test an unknown anime is reported as not found
+func TestGetFranchiseNotFound(t *testing.T) {
+	var calls int32
+	server := franchiseServer(t, nil, &calls)
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, 0).GetFranchise(context.Background(), 404)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// Test relation cycles in AniList's data do not break the story order
+func TestChronologicalOrderWithCycle(t *testing.T) {
+	edges := []FranchiseEdge{
+		{From: 1, To: 2, RelationType: RelationSequel},
+		{From: 2, To: 3, RelationType: RelationSequel},
+		{From: 3, To: 2, RelationType: RelationSequel},
+	}
+	assert.Equal(t, []int{1, 2, 3}, chronologicalOrder([]int{1, 2, 3}, edges))
+}
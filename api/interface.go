@@ -1,14 +1,23 @@
 package api
 
-import "github.com/vrstep/wawatch-backend/models"
+import (
+	"context"
 
-// AniListAPI defines the interface for AniList client operations
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// AniListAPI defines the interface for AniList client operations.
+// Every call is bound to ctx, usually the context of the request being served.
 type AniListAPI interface {
-	GetAnimeByID(id int) (*models.AnimeDetails, error)
-	SearchAnime(query string, page int, perPage int) ([]models.AnimeCache, int, error)
-	GetPopularAnime(page int, perPage int) ([]models.AnimeCache, int, error)
-	GetTrendingAnime(page int, perPage int) ([]models.AnimeCache, int, error)
-	GetAnimeBySeason(year int, season string, page int, perPage int) ([]models.AnimeCache, int, error)
+	GetAnimeByID(ctx context.Context, id int) (*models.AnimeDetails, error)
+	GetAnimeDetailsByID(ctx context.Context, id int) (*models.AnimeDetails, error)
+	GetAnimeByIDs(ctx context.Context, ids []int) ([]models.AnimeDetails, error)
+	SearchAnime(ctx context.Context, query string, page int, perPage int) ([]models.AnimeCache, int, error)
+	GetPopularAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error)
+	GetTrendingAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error)
+	GetAnimeBySeason(ctx context.Context, year int, season string, page int, perPage int) ([]models.AnimeCache, int, error)
+	BrowseAnime(ctx context.Context, filter BrowseFilter, page int, perPage int) (BrowseResult, error)
+	GetFranchise(ctx context.Context, id int) (*Franchise, error)
 	// Add GetAnimeRecommendations if implementing it properly
 }
 
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vrstep/wawatch-backend/ratelimit"
+)
+
+// limiterKey is the single bucket all AniList requests share
+const limiterKey = "anilist"
+
+// limiter keeps every goroutine using a client under AniList's rate limit.
+// Besides the client-side token bucket, it can be paused when AniList reports
+// that the limit was reached anyway (e.g. other instances share our IP).
+type limiter struct {
+	store ratelimit.Store
+	limit ratelimit.Limit
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+func newLimiter(limit ratelimit.Limit) *limiter {
+	return &limiter{store: ratelimit.NewMemoryStore(), limit: limit}
+}
+
+// Wait blocks until a request may be sent or ctx is done
+func (l *limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		delay := time.Until(l.pausedUntil)
+		l.mu.Unlock()
+
+		if delay <= 0 {
+			if l.limit.IsZero() {
+				return nil
+			}
+			result, err := l.store.Take(ctx, limiterKey, l.limit)
+			if err != nil {
+				return err
+			}
+			if result.Allowed {
+				return nil
+			}
+			delay = result.RetryAfter
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// PauseUntil holds back all requests until t; earlier times than the current pause are ignored
+func (l *limiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.pausedUntil) {
+		l.pausedUntil = t
+	}
+}
+
+// sleep waits for d, returning early with the context's error when ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
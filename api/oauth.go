@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/vrstep/wawatch-backend/config"
+)
+
+// OAuthToken is the token response of AniList's authorization-code grant
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AniListViewer is the AniList account an access token belongs to
+type AniListViewer struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Avatar struct {
+		Large string `json:"large"`
+	} `json:"avatar"`
+}
+
+// AniListOAuthAPI is the part of the AniList OAuth2 flow the controllers need
+type AniListOAuthAPI interface {
+	AuthCodeURL(state string) string
+	Exchange(ctx context.Context, code string) (*OAuthToken, error)
+	Viewer(ctx context.Context, accessToken string) (*AniListViewer, error)
+}
+
+// Ensure the real client implements the interface
+var _ AniListOAuthAPI = (*AniListOAuth)(nil)
+
+// AniListOAuth implements the authorization-code flow against the configured AniList endpoints
+type AniListOAuth struct {
+	settings   config.AniListSettings
+	httpClient *http.Client
+}
+
+// NewAniListOAuth creates an OAuth2 client for the given settings
+func NewAniListOAuth(settings config.AniListSettings) *AniListOAuth {
+	return &AniListOAuth{
+		settings: settings,
+		httpClient: &http.Client{
+			Timeout: settings.Timeout,
+		},
+	}
+}
+
+// AuthCodeURL is where the user is sent to approve access; state comes back in the callback
+func (o *AniListOAuth) AuthCodeURL(state string) string {
+	query := url.Values{}
+	query.Set("client_id", o.settings.ClientID)
+	query.Set("redirect_uri", o.settings.RedirectURL)
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	return o.settings.AuthorizeURL + "?" + query.Encode()
+}
+
+// Exchange trades the code from the callback for an access token
+func (o *AniListOAuth) Exchange(ctx context.Context, code string) (*OAuthToken, error) {
+	body, err := o.post(ctx, o.settings.TokenURL, "", map[string]interface{}{
+		"grant_type":    "authorization_code",
+		"client_id":     o.settings.ClientID,
+		"client_secret": o.settings.ClientSecret,
+		"redirect_uri":  o.settings.RedirectURL,
+		"code":          code,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	var token OAuthToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+	return &token, nil
+}
+
+// Viewer returns the account the access token belongs to
+func (o *AniListOAuth) Viewer(ctx context.Context, accessToken string) (*AniListViewer, error) {
+	body, err := o.post(ctx, o.settings.GraphQLURL, accessToken, map[string]interface{}{
+		"query": `query { Viewer { id name avatar { large } } }`,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch viewer: %w", err)
+	}
+
+	var result struct {
+		Data struct {
+			Viewer *AniListViewer `json:"Viewer"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse viewer: %w", err)
+	}
+	if result.Data.Viewer == nil || result.Data.Viewer.ID == 0 {
+		return nil, fmt.Errorf("viewer response has no account")
+	}
+	return result.Data.Viewer, nil
+}
+
+// post sends a JSON body, optionally with a bearer token, and returns the body of a 200 response
+func (o *AniListOAuth) post(ctx context.Context, endpoint, accessToken string, payload map[string]interface{}) ([]byte, error) {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("anilist returned status %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryable reports whether a failed attempt may succeed when repeated:
+// network errors, 429 and 5xx responses are, other statuses and GraphQL errors are not
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	// A 200 with GraphQL errors means the query itself was rejected
+	var graphQLErrs GraphQLErrors
+	return !errors.As(err, &graphQLErrs)
+}
+
+// backoff is the delay before retry number attempt (0-based): base doubled per attempt,
+// with the upper half randomised so concurrent callers do not retry in lockstep
+func backoff(base time.Duration, attempt int) time.Duration {
+	ceiling := base << attempt
+	if ceiling <= 0 || ceiling > time.Minute {
+		ceiling = time.Minute
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// rateLimitPause returns until when requests should stop because the response
+// reported the rate limit as used up, or the zero time if they may continue
+func rateLimitPause(header http.Header, now time.Time) time.Time {
+	if retryAfter := parseRetryAfter(header.Get("Retry-After"), now); retryAfter > 0 {
+		return now.Add(retryAfter)
+	}
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > now.Unix() {
+		return time.Unix(reset, 0)
+	}
+	// AniList's window is one minute
+	return now.Add(time.Minute)
+}
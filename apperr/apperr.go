@@ -0,0 +1,127 @@
+package apperr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vrstep/wawatch-backend/validation"
+)
+
+// Kind classifies an error; it is also the machine readable "code" clients see
+type Kind string
+
+const (
+	KindBadRequest   Kind = "bad_request"
+	KindValidation   Kind = "validation_failed"
+	KindUnauthorized Kind = "unauthorized"
+	KindForbidden    Kind = "forbidden"
+	KindNotFound     Kind = "not_found"
+	KindConflict     Kind = "conflict"
+	KindRateLimited  Kind = "rate_limited"
+	KindUpstream     Kind = "upstream_error"
+	KindUnavailable  Kind = "upstream_unavailable"
+	KindInternal     Kind = "internal_error"
+)
+
+// statusByKind maps each kind to the HTTP status it is rendered with
+var statusByKind = map[Kind]int{
+	KindBadRequest:   http.StatusBadRequest,
+	KindValidation:   http.StatusBadRequest,
+	KindUnauthorized: http.StatusUnauthorized,
+	KindForbidden:    http.StatusForbidden,
+	KindNotFound:     http.StatusNotFound,
+	KindConflict:     http.StatusConflict,
+	KindRateLimited:  http.StatusTooManyRequests,
+	KindUpstream:     http.StatusBadGateway,
+	KindUnavailable:  http.StatusServiceUnavailable,
+	KindInternal:     http.StatusInternalServerError,
+}
+
+// Error is an error that is safe to show to clients.
+// Message and Fields are returned in the response; Err is only logged.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []validation.FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status code for the error
+func (e *Error) Status() int {
+	if status, ok := statusByKind[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// BadRequest is for malformed requests that are not about a specific field
+func BadRequest(message string) *Error {
+	return &Error{Kind: KindBadRequest, Message: message}
+}
+
+// Validation wraps a binding/validation error, reporting one entry per rejected field
+func Validation(err error) *Error {
+	return &Error{Kind: KindValidation, Message: "Invalid input", Fields: validation.FieldErrors(err)}
+}
+
+// InvalidFields reports field errors found by hand-written checks
+func InvalidFields(fields ...validation.FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: "Invalid input", Fields: fields}
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+// Conflict is for requests that clash with existing data, optionally naming the fields involved
+func Conflict(message string, fields ...validation.FieldError) *Error {
+	return &Error{Kind: KindConflict, Message: message, Fields: fields}
+}
+
+// TooManyRequests is for clients that hit a rate limit
+func TooManyRequests(message string) *Error {
+	return &Error{Kind: KindRateLimited, Message: message}
+}
+
+// Upstream is for failures of external services such as AniList
+func Upstream(message string, err error) *Error {
+	return &Error{Kind: KindUpstream, Message: message, Err: err}
+}
+
+// Unavailable is for external services that are temporarily refusing requests, e.g. AniList's rate limit
+func Unavailable(message string, err error) *Error {
+	return &Error{Kind: KindUnavailable, Message: message, Err: err}
+}
+
+// Internal is for everything else; err is logged, message is shown
+func Internal(message string, err error) *Error {
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
+
+// From returns err as an *Error, treating unknown errors as internal ones
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal("Internal server error", err)
+}
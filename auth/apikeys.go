@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// Scope is a permission an API key can be granted; sessions implicitly have all of them
+type Scope string
+
+const (
+	ScopeListRead    Scope = "list:read"
+	ScopeListWrite   Scope = "list:write"
+	ScopeProfileRead Scope = "profile:read"
+)
+
+// AllScopes lists every scope, in the order they are shown to users
+var AllScopes = []Scope{ScopeListRead, ScopeListWrite, ScopeProfileRead}
+
+// IsValidScope reports whether s names a known scope
+func IsValidScope(s string) bool {
+	for _, scope := range AllScopes {
+		if string(scope) == s {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	apiKeyPrefix = "wwk_"
+	// APIKeyVisibleLength is how much of a key is stored in clear so users can tell their keys apart
+	APIKeyVisibleLength = len(apiKeyPrefix) + 8
+)
+
+// NewAPIKey returns a random API key such as "wwk_3q2-7wEv..." and the visible part of it.
+// Only the visible part and HashToken(key) are stored.
+func NewAPIKey() (key, visible string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, key[:APIKeyVisibleLength], nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/vrstep/wawatch-backend/config"
+)
+
+// LockoutDuration is how long an account stays locked after the given number of
+// consecutive failed logins. It is zero below the threshold and doubles with every
+// failure past it, capped at MaxLockoutDuration.
+func LockoutDuration(failures int, settings config.AuthSettings) time.Duration {
+	if settings.MaxFailedLogins <= 0 || failures < settings.MaxFailedLogins {
+		return 0
+	}
+
+	lockout := settings.LockoutDuration
+	for i := settings.MaxFailedLogins; i < failures; i++ {
+		lockout *= 2
+		if lockout >= settings.MaxLockoutDuration {
+			return settings.MaxLockoutDuration
+		}
+	}
+	return lockout
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "github.com/vrstep/wawatch-backend/models"
+
+// Permission is an action that only some roles may perform
+type Permission string
+
+const (
+	PermManageProviders Permission = "providers:manage" // Edit and delete watch providers
+	PermManageUsers     Permission = "users:manage"     // Admin user management
+)
+
+// rolePermissions lists what each role may do on top of regular user actions
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:      {},
+	models.RoleModerator: {PermManageProviders},
+	models.RoleAdmin:     {PermManageProviders, PermManageUsers},
+}
+
+// NormalizeRole maps the empty role of accounts created before roles were enforced to RoleUser
+func NormalizeRole(role string) string {
+	if role == "" {
+		return models.RoleUser
+	}
+	return role
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
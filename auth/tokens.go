@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vrstep/wawatch-backend/config"
+)
+
+// AccessClaims are the claims carried by access tokens.
+// Subject holds the user ID, SessionID the session the token was issued for.
+type AccessClaims struct {
+	SessionID uint `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the numeric user ID stored in the subject claim
+func (c *AccessClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid subject claim")
+	}
+	return uint(id), nil
+}
+
+// IssueAccessToken signs a short-lived HS256 access token for the given session
+func IssueAccessToken(userID, sessionID uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.AppSettings.Auth.TokenTTL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, AccessClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+
+	signed, err := token.SignedString([]byte(config.AppSettings.Auth.JWTSecret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppSettings.Auth.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == 0 {
+		return nil, errors.New("token is not bound to a session")
+	}
+	return claims, nil
+}
+
+// NewOpaqueToken returns a random URL-safe token suitable for refresh tokens and similar secrets
+func NewOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the SHA-256 hex digest stored in place of an opaque token.
+// The tokens carry 256 bits of entropy, so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Audiences of short-lived tokens that only work for one step of a flow
+const (
+	PurposeMFA        = "mfa"         // Password was right, a second factor is still missing
+	PurposeOAuthState = "oauth_state" // Round trip through an external OAuth provider
+)
+
+// IssuePurposeToken signs a short-lived token usable for a single purpose only.
+// Such tokens carry no session, so ParseAccessToken rejects them.
+func IssuePurposeToken(purpose, subject string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{purpose},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	})
+	return token.SignedString([]byte(config.AppSettings.Auth.JWTSecret))
+}
+
+// ParsePurposeToken verifies a token from IssuePurposeToken and returns its subject
+func ParsePurposeToken(tokenString, purpose string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppSettings.Auth.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithAudience(purpose))
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
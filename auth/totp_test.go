@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 test secret "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := TOTPStep(now)
+	previous, _ := TOTPCode(rfcSecret, current-1)
+
+	step, ok := VerifyTOTP(rfcSecret, "081 804", now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, current, step)
+
+	// One step of clock drift is tolerated
+	_, ok = VerifyTOTP(rfcSecret, previous, now, 0)
+	assert.True(t, ok)
+
+	// A used step cannot be replayed
+	_, ok = VerifyTOTP(rfcSecret, "081804", now, current)
+	assert.False(t, ok)
+
+	_, ok = VerifyTOTP(rfcSecret, "000000", now, 0)
+	assert.False(t, ok)
+}
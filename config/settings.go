@@ -0,0 +1,567 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/vrstep/wawatch-backend/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings holds every runtime option of the service.
+// Values are resolved in this order (later wins): defaults, YAML file, environment (.env included).
+type Settings struct {
+	Env       string            `yaml:"env"`
+	PublicURL string            `yaml:"public_url"` // Base URL of the web frontend, used in emailed links
+	HTTP      HTTPSettings      `yaml:"http"`
+	Database  DatabaseSettings  `yaml:"database"`
+	Auth      AuthSettings      `yaml:"auth"`
+	Mail      MailSettings      `yaml:"mail"`
+	RateLimit RateLimitSettings `yaml:"rate_limit"`
+	AniList   AniListSettings   `yaml:"anilist"`
+	Account   AccountSettings   `yaml:"account"`
+	Cache     CacheSettings     `yaml:"cache"`
+}
+
+type HTTPSettings struct {
+	Addr              string        `yaml:"addr"` // e.g. ":8080"
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"` // How long in-flight requests get to finish on SIGTERM
+	// IPs or CIDRs of the reverse proxies whose X-Forwarded-For is believed.
+	// Empty means none: the client IP, and so the per-IP rate limits, use the peer address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type DatabaseSettings struct {
+	DSN            string `yaml:"dsn"`
+	MigrationsPath string `yaml:"migrations_path"` // Source URL understood by golang-migrate
+}
+
+type AuthSettings struct {
+	JWTSecret       string        `yaml:"jwt_secret"`
+	TokenTTL        time.Duration `yaml:"token_ttl"`         // Lifetime of access tokens
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"` // Sessions expire after this long without a refresh
+
+	// Auth cookie set by Login; clients may send the token as a Bearer header instead
+	CookieDomain string        `yaml:"cookie_domain"` // Empty means host-only
+	CookieSecure bool          `yaml:"cookie_secure"`
+	CookieMaxAge time.Duration `yaml:"cookie_max_age"` // Zero means "same as TokenTTL"
+
+	PasswordResetTTL     time.Duration `yaml:"password_reset_ttl"`
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl"`
+
+	// Progressive lockout: after MaxFailedLogins failures in a row the account is locked for
+	// LockoutDuration, doubling with every further failure up to MaxLockoutDuration
+	MaxFailedLogins    int           `yaml:"max_failed_logins"`
+	LockoutDuration    time.Duration `yaml:"lockout_duration"`
+	MaxLockoutDuration time.Duration `yaml:"max_lockout_duration"`
+
+	MFATokenTTL time.Duration `yaml:"mfa_token_ttl"` // How long the second login step may take
+	TOTPIssuer  string        `yaml:"totp_issuer"`   // Name shown in authenticator apps
+
+	// Accounts without a password confirm sensitive changes by having logged in this recently
+	ReauthWindow time.Duration `yaml:"reauth_window"`
+}
+
+type MailSettings struct {
+	Driver       string `yaml:"driver"` // smtp, file or memory
+	From         string `yaml:"from"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	// SMTPTimeout bounds connecting to the relay and the whole exchange of one mail
+	SMTPTimeout time.Duration `yaml:"smtp_timeout"`
+	FileDir     string        `yaml:"file_dir"` // Where the file driver writes .eml files
+}
+
+// AniListSettings configure the AniList API and the "Login with AniList" OAuth2 client.
+// The endpoints can point to a local stub in tests.
+type AniListSettings struct {
+	GraphQLURL   string `yaml:"graphql_url"`
+	AuthorizeURL string `yaml:"authorize_url"`
+	TokenURL     string `yaml:"token_url"`
+
+	// API client behaviour; AniList allows 90 requests per minute per IP
+	Timeout        time.Duration   `yaml:"timeout"`          // Per attempt
+	MaxRetries     int             `yaml:"max_retries"`      // Retries after network errors, 5xx and 429 responses
+	RetryBaseDelay time.Duration   `yaml:"retry_base_delay"` // Doubled on every retry, with jitter
+	RateLimit      ratelimit.Limit `yaml:"rate_limit"`       // Client-side limit shared by all requests
+	FranchiseTTL   time.Duration   `yaml:"franchise_ttl"`    // How long franchise graphs are kept in memory, zero disables
+
+	// OAuth2 client registered at https://anilist.co/settings/developer; empty disables AniList login
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"` // Must match the client's redirect URL, e.g. https://api.example.com/auth/anilist/callback
+}
+
+// OAuthEnabled reports whether AniList login is configured
+func (a AniListSettings) OAuthEnabled() bool {
+	return a.ClientID != ""
+}
+
+// CacheSettings control how long anime details fetched from AniList are served from the database
+type CacheSettings struct {
+	ReleasingTTL         time.Duration `yaml:"releasing_ttl"`          // Airing or upcoming anime change often
+	FinishedTTL          time.Duration `yaml:"finished_ttl"`           // Finished or cancelled anime hardly change
+	DefaultTTL           time.Duration `yaml:"default_ttl"`            // Any other status, e.g. HIATUS
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"` // How long expired details are served while refreshed in the background
+	RefreshInterval      time.Duration `yaml:"refresh_interval"`       // How often airing anime on users' lists are refetched
+	AutocompleteTTL      time.Duration `yaml:"autocomplete_ttl"`       // How long suggestions for a query are reused, zero disables
+}
+
+// AccountSettings control account deletion
+type AccountSettings struct {
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period"` // Logging in during this period cancels the deletion
+	PurgeInterval       time.Duration `yaml:"purge_interval"`        // How often accounts past their grace period are purged
+}
+
+// RateLimitSettings are the per-IP request limits of the public endpoints,
+// and the per-user limits of the anime list, which API keys may script
+type RateLimitSettings struct {
+	Enabled      bool            `yaml:"enabled"`
+	Login        ratelimit.Limit `yaml:"login"`
+	Signup       ratelimit.Limit `yaml:"signup"`
+	Auth         ratelimit.Limit `yaml:"auth"`         // Everything under /auth
+	Search       ratelimit.Limit `yaml:"search"`       // Anime search, which is public
+	Autocomplete ratelimit.Limit `yaml:"autocomplete"` // Search suggestions, requested on every keystroke
+	Browse       ratelimit.Limit `yaml:"browse"`       // Filtered anime lists, up to five AniList queries each
+	ListRead     ratelimit.Limit `yaml:"list_read"`    // Reading the own anime list, per user
+	ListWrite    ratelimit.Limit `yaml:"list_write"`   // Changing the own anime list, per user
+}
+
+// CookieMaxAgeSeconds is the Max-Age to send with the auth cookie
+func (a AuthSettings) CookieMaxAgeSeconds() int {
+	if a.CookieMaxAge > 0 {
+		return int(a.CookieMaxAge.Seconds())
+	}
+	return int(a.TokenTTL.Seconds())
+}
+
+// AppSettings is the configuration the application was started with.
+// It starts out with the defaults so packages can be used (and tested) without calling LoadSettings.
+var AppSettings = DefaultSettings()
+
+// DefaultSettings returns the settings used when nothing else is configured.
+// Secrets intentionally have no default.
+func DefaultSettings() Settings {
+	return Settings{
+		Env:       "development",
+		PublicURL: "http://localhost:3000",
+		HTTP: HTTPSettings{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseSettings{
+			MigrationsPath: "file://db/migrations",
+		},
+		Auth: AuthSettings{
+			TokenTTL:        15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+
+			PasswordResetTTL:     time.Hour,
+			EmailVerificationTTL: 48 * time.Hour,
+
+			MaxFailedLogins:    5,
+			LockoutDuration:    time.Minute,
+			MaxLockoutDuration: 24 * time.Hour,
+
+			MFATokenTTL: 5 * time.Minute,
+			TOTPIssuer:  "WaWatch",
+
+			ReauthWindow: 10 * time.Minute,
+		},
+		Mail: MailSettings{
+			Driver:      "file",
+			From:        "WaWatch <no-reply@wawatch.local>",
+			SMTPPort:    587,
+			SMTPTimeout: 10 * time.Second,
+			FileDir:     "tmp/mail",
+		},
+		AniList: AniListSettings{
+			GraphQLURL:   "https://graphql.anilist.co",
+			AuthorizeURL: "https://anilist.co/api/v2/oauth/authorize",
+			TokenURL:     "https://anilist.co/api/v2/oauth/token",
+
+			Timeout:        10 * time.Second,
+			MaxRetries:     3,
+			RetryBaseDelay: 500 * time.Millisecond,
+			RateLimit:      ratelimit.PerMinute(90),
+			FranchiseTTL:   24 * time.Hour,
+		},
+		Cache: CacheSettings{
+			ReleasingTTL:         time.Hour,
+			FinishedTTL:          7 * 24 * time.Hour,
+			DefaultTTL:           24 * time.Hour,
+			StaleWhileRevalidate: 7 * 24 * time.Hour,
+			RefreshInterval:      6 * time.Hour,
+			AutocompleteTTL:      time.Minute,
+		},
+		Account: AccountSettings{
+			DeletionGracePeriod: 14 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+		},
+		RateLimit: RateLimitSettings{
+			Enabled:      true,
+			Login:        ratelimit.PerMinute(10),
+			Signup:       ratelimit.Limit{Burst: 10, Period: time.Hour},
+			Auth:         ratelimit.PerMinute(20),
+			Search:       ratelimit.PerMinute(60),
+			Autocomplete: ratelimit.PerMinute(300),
+			Browse:       ratelimit.PerMinute(30),
+			ListRead:     ratelimit.PerMinute(120),
+			ListWrite:    ratelimit.PerMinute(60),
+		},
+	}
+}
+
+// LoadSettings builds the settings from the optional YAML file (CONFIG_FILE),
+// the optional .env file and the process environment, then validates them.
+func LoadSettings() (Settings, error) {
+	settings := DefaultSettings()
+
+	// A missing .env is fine, real deployments set the environment directly
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return settings, fmt.Errorf("failed to load .env file: %w", err)
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return settings, fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return settings, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&settings); err != nil {
+		return settings, err
+	}
+
+	if err := settings.Validate(); err != nil {
+		return settings, err
+	}
+
+	return settings, nil
+}
+
+// Validate reports every missing or malformed option at once
+func (s Settings) Validate() error {
+	var problems []string
+
+	if s.Database.DSN == "" {
+		problems = append(problems, "DATABASE_URL is required")
+	}
+	if s.Auth.JWTSecret == "" {
+		problems = append(problems, "JWT_SECRET is required")
+	} else if s.IsProduction() && len(s.Auth.JWTSecret) < 32 {
+		problems = append(problems, "JWT_SECRET must be at least 32 characters in production")
+	}
+	if s.Auth.TokenTTL <= 0 {
+		problems = append(problems, "JWT_TTL must be positive")
+	}
+	if s.Auth.RefreshTokenTTL <= s.Auth.TokenTTL {
+		problems = append(problems, "REFRESH_TOKEN_TTL must be longer than JWT_TTL")
+	}
+	if s.HTTP.Addr == "" {
+		problems = append(problems, "HTTP_ADDR (or PORT) is required")
+	}
+	switch s.Mail.Driver {
+	case "smtp":
+		if s.Mail.SMTPHost == "" {
+			problems = append(problems, "SMTP_HOST is required for the smtp mail driver")
+		}
+		if s.Mail.SMTPTimeout <= 0 {
+			problems = append(problems, "SMTP_TIMEOUT must be positive")
+		}
+	case "file", "memory":
+		if s.IsProduction() {
+			problems = append(problems, "MAIL_DRIVER must be smtp in production")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown MAIL_DRIVER %q", s.Mail.Driver))
+	}
+	if s.Auth.MaxFailedLogins > 0 && (s.Auth.LockoutDuration <= 0 || s.Auth.MaxLockoutDuration < s.Auth.LockoutDuration) {
+		problems = append(problems, "LOGIN_LOCKOUT_DURATION must be positive and at most LOGIN_MAX_LOCKOUT_DURATION")
+	}
+	if s.Auth.MFATokenTTL <= 0 {
+		problems = append(problems, "MFA_TOKEN_TTL must be positive")
+	}
+	if s.Auth.ReauthWindow <= 0 {
+		problems = append(problems, "REAUTH_WINDOW must be positive")
+	}
+	if s.AniList.OAuthEnabled() && (s.AniList.ClientSecret == "" || s.AniList.RedirectURL == "") {
+		problems = append(problems, "ANILIST_CLIENT_SECRET and ANILIST_REDIRECT_URL are required with ANILIST_CLIENT_ID")
+	}
+	if s.AniList.Timeout <= 0 || s.AniList.MaxRetries < 0 || s.AniList.RetryBaseDelay <= 0 || s.AniList.FranchiseTTL < 0 {
+		problems = append(problems, "ANILIST_TIMEOUT and ANILIST_RETRY_BASE_DELAY must be positive and ANILIST_MAX_RETRIES and ANILIST_FRANCHISE_TTL must not be negative")
+	}
+	if s.Cache.ReleasingTTL <= 0 || s.Cache.FinishedTTL <= 0 || s.Cache.DefaultTTL <= 0 || s.Cache.StaleWhileRevalidate < 0 || s.Cache.RefreshInterval <= 0 || s.Cache.AutocompleteTTL < 0 {
+		problems = append(problems, "ANIME_CACHE_TTL_* and ANIME_CACHE_REFRESH_INTERVAL must be positive and ANIME_CACHE_STALE_WHILE_REVALIDATE and ANIME_CACHE_AUTOCOMPLETE_TTL must not be negative")
+	}
+	if s.Account.DeletionGracePeriod < 0 || s.Account.PurgeInterval <= 0 {
+		problems = append(problems, "ACCOUNT_DELETION_GRACE_PERIOD must not be negative and ACCOUNT_PURGE_INTERVAL must be positive")
+	}
+	if s.HTTP.ShutdownTimeout <= 0 {
+		problems = append(problems, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+	}
+	for _, proxy := range s.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES entry %q is not an IP or CIDR", proxy))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// IsProduction reports whether the service runs in the production environment
+func (s Settings) IsProduction() bool {
+	return s.Env == "production"
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func applyEnv(s *Settings) error {
+	setString(&s.Env, "APP_ENV")
+	setString(&s.PublicURL, "PUBLIC_URL")
+
+	setString(&s.HTTP.Addr, "HTTP_ADDR")
+	// PORT is what most hosting platforms inject
+	if port := os.Getenv("PORT"); port != "" && os.Getenv("HTTP_ADDR") == "" {
+		s.HTTP.Addr = ":" + port
+	}
+	durations := map[string]*time.Duration{
+		"HTTP_READ_TIMEOUT":        &s.HTTP.ReadTimeout,
+		"HTTP_READ_HEADER_TIMEOUT": &s.HTTP.ReadHeaderTimeout,
+		"HTTP_WRITE_TIMEOUT":       &s.HTTP.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        &s.HTTP.IdleTimeout,
+		"HTTP_SHUTDOWN_TIMEOUT":    &s.HTTP.ShutdownTimeout,
+	}
+	for key, target := range durations {
+		if err := setDuration(target, key); err != nil {
+			return err
+		}
+	}
+	setList(&s.HTTP.TrustedProxies, "TRUSTED_PROXIES")
+
+	setString(&s.Database.DSN, "DATABASE_URL")
+	setString(&s.Database.MigrationsPath, "MIGRATIONS_PATH")
+
+	setString(&s.Auth.JWTSecret, "JWT_SECRET")
+	if err := setDuration(&s.Auth.TokenTTL, "JWT_TTL"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL"); err != nil {
+		return err
+	}
+	setString(&s.Auth.CookieDomain, "AUTH_COOKIE_DOMAIN")
+	if err := setBool(&s.Auth.CookieSecure, "AUTH_COOKIE_SECURE"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.CookieMaxAge, "AUTH_COOKIE_MAX_AGE"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.PasswordResetTTL, "PASSWORD_RESET_TTL"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.EmailVerificationTTL, "EMAIL_VERIFICATION_TTL"); err != nil {
+		return err
+	}
+
+	if err := setInt(&s.Auth.MaxFailedLogins, "LOGIN_MAX_FAILED_ATTEMPTS"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.LockoutDuration, "LOGIN_LOCKOUT_DURATION"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Auth.MaxLockoutDuration, "LOGIN_MAX_LOCKOUT_DURATION"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&s.Auth.MFATokenTTL, "MFA_TOKEN_TTL"); err != nil {
+		return err
+	}
+	setString(&s.Auth.TOTPIssuer, "TOTP_ISSUER")
+	if err := setDuration(&s.Auth.ReauthWindow, "REAUTH_WINDOW"); err != nil {
+		return err
+	}
+
+	setString(&s.Mail.Driver, "MAIL_DRIVER")
+	setString(&s.Mail.From, "MAIL_FROM")
+	setString(&s.Mail.SMTPHost, "SMTP_HOST")
+	if err := setInt(&s.Mail.SMTPPort, "SMTP_PORT"); err != nil {
+		return err
+	}
+	setString(&s.Mail.SMTPUsername, "SMTP_USERNAME")
+	setString(&s.Mail.SMTPPassword, "SMTP_PASSWORD")
+	if err := setDuration(&s.Mail.SMTPTimeout, "SMTP_TIMEOUT"); err != nil {
+		return err
+	}
+	setString(&s.Mail.FileDir, "MAIL_FILE_DIR")
+
+	setString(&s.AniList.GraphQLURL, "ANILIST_GRAPHQL_URL")
+	setString(&s.AniList.AuthorizeURL, "ANILIST_AUTHORIZE_URL")
+	setString(&s.AniList.TokenURL, "ANILIST_TOKEN_URL")
+	setString(&s.AniList.ClientID, "ANILIST_CLIENT_ID")
+	setString(&s.AniList.ClientSecret, "ANILIST_CLIENT_SECRET")
+	setString(&s.AniList.RedirectURL, "ANILIST_REDIRECT_URL")
+	if err := setDuration(&s.AniList.Timeout, "ANILIST_TIMEOUT"); err != nil {
+		return err
+	}
+	if err := setInt(&s.AniList.MaxRetries, "ANILIST_MAX_RETRIES"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.AniList.RetryBaseDelay, "ANILIST_RETRY_BASE_DELAY"); err != nil {
+		return err
+	}
+	if err := setLimit(&s.AniList.RateLimit, "ANILIST_RATE_LIMIT"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.AniList.FranchiseTTL, "ANILIST_FRANCHISE_TTL"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&s.Cache.ReleasingTTL, "ANIME_CACHE_TTL_RELEASING"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Cache.FinishedTTL, "ANIME_CACHE_TTL_FINISHED"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Cache.DefaultTTL, "ANIME_CACHE_TTL_DEFAULT"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Cache.StaleWhileRevalidate, "ANIME_CACHE_STALE_WHILE_REVALIDATE"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Cache.RefreshInterval, "ANIME_CACHE_REFRESH_INTERVAL"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Cache.AutocompleteTTL, "ANIME_CACHE_AUTOCOMPLETE_TTL"); err != nil {
+		return err
+	}
+
+	if err := setDuration(&s.Account.DeletionGracePeriod, "ACCOUNT_DELETION_GRACE_PERIOD"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.Account.PurgeInterval, "ACCOUNT_PURGE_INTERVAL"); err != nil {
+		return err
+	}
+
+	if err := setBool(&s.RateLimit.Enabled, "RATE_LIMIT_ENABLED"); err != nil {
+		return err
+	}
+	limits := map[string]*ratelimit.Limit{
+		"RATE_LIMIT_LOGIN":        &s.RateLimit.Login,
+		"RATE_LIMIT_SIGNUP":       &s.RateLimit.Signup,
+		"RATE_LIMIT_AUTH":         &s.RateLimit.Auth,
+		"RATE_LIMIT_SEARCH":       &s.RateLimit.Search,
+		"RATE_LIMIT_AUTOCOMPLETE": &s.RateLimit.Autocomplete,
+		"RATE_LIMIT_BROWSE":       &s.RateLimit.Browse,
+		"RATE_LIMIT_LIST_READ":    &s.RateLimit.ListRead,
+		"RATE_LIMIT_LIST_WRITE":   &s.RateLimit.ListWrite,
+	}
+	for key, target := range limits {
+		if err := setLimit(target, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setString(target *string, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		*target = value
+	}
+}
+
+// setList parses comma separated values; an empty value clears the list
+func setList(target *[]string, key string) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	*target = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*target = append(*target, item)
+		}
+	}
+}
+
+func setDuration(target *time.Duration, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*target = d
+	return nil
+}
+
+func setBool(target *bool, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*target = b
+	return nil
+}
+
+func setInt(target *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*target = i
+	return nil
+}
+
+// setLimit parses limits written as "<requests>/<period>", e.g. "10/1m"
+func setLimit(target *ratelimit.Limit, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	count, period, found := strings.Cut(value, "/")
+	if !found {
+		return fmt.Errorf("invalid %s %q: expected <requests>/<period>", key, value)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	*target = ratelimit.Limit{Burst: burst, Period: d}
+	return nil
+}
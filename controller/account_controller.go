@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/mailer"
+	"github.com/vrstep/wawatch-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Use the interface type so tests can inspect sent mail
+var mailClient mailer.Mailer
+
+// SetMailer allows injecting a mailer (SMTP, file or in-memory)
+func SetMailer(m mailer.Mailer) {
+	mailClient = m
+}
+
+// Default to the in-memory mailer until main wires the configured one
+func init() {
+	SetMailer(mailer.NewMemoryMailer())
+}
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// mailTimeout bounds mail sent in the background, which outlives the request that asked for it
+const mailTimeout = time.Minute
+
+// createUserToken issues a new single-use token and invalidates older unused ones of the same purpose
+func createUserToken(user models.User, purpose string, ttl time.Duration) (string, error) {
+	plain, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := config.DB.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+		Update("used_at", now).Error; err != nil {
+		return "", err
+	}
+
+	token := models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(plain),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := config.DB.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// consumeUserToken marks a valid token as used and returns it; each token works exactly once
+func consumeUserToken(plain, purpose string) (models.UserToken, error) {
+	var token models.UserToken
+	now := time.Now()
+	if err := config.DB.
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", auth.HashToken(plain), purpose, now).
+		First(&token).Error; err != nil {
+		return token, errInvalidUserToken
+	}
+
+	// Conditional update so two concurrent requests cannot both use the token
+	result := config.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return token, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return token, errInvalidUserToken
+	}
+	return token, nil
+}
+
+// frontendLink builds a link to a frontend page carrying the token
+func frontendLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", config.AppSettings.PublicURL, path, url.QueryEscape(token))
+}
+
+// sendVerificationEmail emails the user a link confirming their current address
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	if user.Email == "" {
+		return nil
+	}
+	token, err := createUserToken(user, models.TokenEmailVerification, config.AppSettings.Auth.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailClient.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your WaWatch email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s. If you did not sign up, ignore this email.\n",
+			user.Username, frontendLink("/verify-email", token), config.AppSettings.Auth.EmailVerificationTTL),
+	})
+}
+
+// ForgotPassword emails a password reset link.
+// The response is the same, and as quick, whether or not the address is known, so it cannot be used to probe accounts.
+func ForgotPassword(c *gin.Context) {
+	var body struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	// The lookup and the mail happen after answering, so known and unknown addresses take equally long
+	goBackground(mailTimeout, func(ctx context.Context) {
+		sendPasswordReset(ctx, body.Email)
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "If an account with that email exists, a reset link has been sent"})
+}
+
+// sendPasswordReset emails a reset link to the user with the address, if there is one
+func sendPasswordReset(ctx context.Context, email string) {
+	var user models.User
+	if err := config.DB.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to look up user for password reset: %v", err)
+		}
+		return
+	}
+
+	token, err := createUserToken(user, models.TokenPasswordReset, config.AppSettings.Auth.PasswordResetTTL)
+	if err != nil {
+		log.Printf("Failed to create password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	if err := mailClient.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your WaWatch password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. Open this link to choose a new one:\n\n%s\n\nThe link expires in %s. If it was not you, ignore this email.\n",
+			user.Username, frontendLink("/reset-password", token), config.AppSettings.Auth.PasswordResetTTL),
+	}); err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and signs the user out everywhere
+func ResetPassword(c *gin.Context) {
+	var body struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,password"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	token, err := consumeUserToken(body.Token, models.TokenPasswordReset)
+	if err != nil {
+		c.Error(apperr.BadRequest("Invalid or expired token"))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), 10)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to hash password", err))
+		return
+	}
+
+	if err := config.DB.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+		"password": string(hash),
+		// Whoever can read the mailbox may log in again right away
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error; err != nil {
+		c.Error(apperr.Internal("Failed to reset password", err))
+		return
+	}
+	if err := revokeAllSessions(token.UserID); err != nil {
+		c.Error(apperr.Internal("Failed to revoke sessions", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// VerifyEmail confirms the address a verification token was sent to
+func VerifyEmail(c *gin.Context) {
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	token, err := consumeUserToken(body.Token, models.TokenEmailVerification)
+	if err != nil {
+		c.Error(apperr.BadRequest("Invalid or expired token"))
+		return
+	}
+
+	// Only verify if the user still has the address the link was sent to
+	result := config.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", token.UserID, token.Email).
+		Update("email_verified_at", time.Now())
+	if result.Error != nil {
+		c.Error(apperr.Internal("Failed to verify email", result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Error(apperr.BadRequest("Invalid or expired token"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerificationEmail sends a fresh verification link to the logged-in user
+func ResendVerificationEmail(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	if user.Email == "" {
+		c.Error(apperr.BadRequest("No email address on this account"))
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.Error(apperr.BadRequest("Email is already verified"))
+		return
+	}
+
+	if err := sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		c.Error(apperr.Internal("Failed to send verification email", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/mailer"
+)
+
+var userColumns = []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "email", "email_verified_at", "role", "profile_picture"}
+
+// Test ForgotPassword emails a reset link to a known address
+func TestForgotPasswordSendsResetLink(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mail := mailer.NewMemoryMailer()
+	SetMailer(mail)
+
+	now := time.Now()
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE email = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs("reset@example.com", 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(4, now, now, nil, "resetuser", "hash", "reset@example.com", nil, "user", "default.jpg"))
+
+	// Older reset tokens are invalidated, then the new one is stored
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "user_tokens" SET "used_at"=$1,"updated_at"=$2 WHERE (user_id = $3 AND purpose = $4 AND used_at IS NULL) AND "user_tokens"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 4, "password_reset").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "user_tokens"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	router.POST("/auth/forgot-password", ForgotPassword)
+
+	body, _ := json.Marshal(map[string]string{"email": "reset@example.com"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	waitBackground()
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	sent := mail.Sent()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "reset@example.com", sent[0].To)
+		assert.True(t, strings.Contains(sent[0].Body, "/reset-password?token="))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test ForgotPassword answers the same way for unknown addresses without sending mail
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mail := mailer.NewMemoryMailer()
+	SetMailer(mail)
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE email = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs("nobody@example.com", 1).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+
+	router.POST("/auth/forgot-password", ForgotPassword)
+
+	body, _ := json.Marshal(map[string]string{"email": "nobody@example.com"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	waitBackground()
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, mail.Sent())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// blockingMailer holds every mail until released, like a slow SMTP server
+type blockingMailer struct {
+	release chan struct{}
+}
+
+func (m blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	select {
+	case <-m.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Test ForgotPassword answers a known address without waiting for the mail to be sent
+func TestForgotPasswordDoesNotWaitForMail(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mail := blockingMailer{release: make(chan struct{})}
+	SetMailer(mail)
+	t.Cleanup(func() { SetMailer(mailer.NewMemoryMailer()) })
+
+	now := time.Now()
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE email = $1`)).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(4, now, now, nil, "resetuser", "hash", "reset@example.com", nil, "user", "default.jpg"))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "user_tokens" SET "used_at"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "user_tokens"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	router.POST("/auth/forgot-password", ForgotPassword)
+
+	body, _ := json.Marshal(map[string]string{"email": "reset@example.com"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	// Answered while the mail is still on its way
+	assert.Equal(t, http.StatusOK, w.Code)
+	close(mail.release)
+	waitBackground()
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test ResetPassword rejects unknown or used tokens
+func TestResetPasswordInvalidToken(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_tokens" WHERE (token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3) AND "user_tokens"."deleted_at" IS NULL ORDER BY "user_tokens"."id" LIMIT $4`)).
+		WithArgs(sqlmock.AnyArg(), "password_reset", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	router.POST("/auth/reset-password", ResetPassword)
+
+	body, _ := json.Marshal(map[string]string{"token": "bogus", "password": "n3w-password"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
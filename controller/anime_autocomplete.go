@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// autocompleteCandidates is how many matches are loaded, and cached, per query;
+	// the user's list entries among them are moved up before the response is cut
+	autocompleteCandidates = 50
+	// autocompleteCacheSize bounds the number of cached queries
+	autocompleteCacheSize = 10000
+	// autocompleteRecencyHalfLife halves the boost of list entries not touched for this long
+	autocompleteRecencyHalfLife = 30 * 24 * time.Hour
+)
+
+// autocompleteCondition matches every word of the query as a prefix through the
+// tsvector, or the whole query as the start of the display or romaji title
+const autocompleteCondition = "search_vector @@ to_tsquery('simple', @prefix) OR lower(title) LIKE @like OR lower(title_romaji) LIKE @like"
+
+// autocompleteRank puts titles starting with the query first, then the best
+// full-text matches, then the shortest titles, which are usually the first season
+const autocompleteRank = "(lower(title) LIKE @like OR lower(title_romaji) LIKE @like) DESC, ts_rank(search_vector, to_tsquery('simple', @prefix)) DESC, length(title), id"
+
+// AnimeSuggestion is a compact search result for search-as-you-type
+type AnimeSuggestion struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	CoverImage string `json:"cover_image"`
+	Year       *int   `json:"year"`
+	InList     bool   `json:"in_list,omitempty"` // On the logged-in user's list
+}
+
+type autocompleteEntry struct {
+	suggestions []AnimeSuggestion
+	expiresAt   time.Time
+}
+
+// autocompleteCache keeps the candidates of recent queries, so the requests a
+// search box sends while the user types and deletes characters are answered from memory
+type autocompleteCache struct {
+	mu      sync.Mutex
+	entries map[string]autocompleteEntry
+}
+
+var suggestionCache = &autocompleteCache{entries: make(map[string]autocompleteEntry)}
+
+func (c *autocompleteCache) get(query string, now time.Time) ([]AnimeSuggestion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[query]
+	if !ok || !now.Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.suggestions, true
+}
+
+func (c *autocompleteCache) set(query string, suggestions []AnimeSuggestion, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= autocompleteCacheSize {
+		now := time.Now()
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		// Still full of live entries: start over rather than track usage
+		if len(c.entries) >= autocompleteCacheSize {
+			c.entries = make(map[string]autocompleteEntry)
+		}
+	}
+	c.entries[query] = autocompleteEntry{suggestions: suggestions, expiresAt: expiresAt}
+}
+
+// normalizeAutocompleteQuery lower-cases the query and collapses whitespace, so
+// "Frieren " and "frieren" share a cache entry
+func normalizeAutocompleteQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// prefixTSQuery turns "sousou no fri" into "sousou:* & no:* & fri:*". Only letters
+// and digits are kept, so user input cannot inject tsquery operators.
+func prefixTSQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// animeSuggestions returns the best matches for the normalised query from the
+// local catalogue, or from the cache while they are fresh
+func animeSuggestions(ctx context.Context, query string) ([]AnimeSuggestion, error) {
+	ttl := config.AppSettings.Cache.AutocompleteTTL
+	if ttl > 0 {
+		if suggestions, ok := suggestionCache.get(query, time.Now()); ok {
+			return suggestions, nil
+		}
+	}
+
+	suggestions := []AnimeSuggestion{}
+	prefix := prefixTSQuery(query)
+	if prefix == "" {
+		return suggestions, nil
+	}
+
+	vars := []interface{}{sql.Named("prefix", prefix), sql.Named("like", escapeLike(query)+"%")}
+	var animes []models.AnimeCache
+	if err := config.DB.WithContext(ctx).
+		Select("id", "title", "cover_image", "season_year").
+		Where(autocompleteCondition, vars...).
+		Clauses(clause.OrderBy{Expression: clause.NamedExpr{SQL: autocompleteRank, Vars: vars}}).
+		Limit(autocompleteCandidates).
+		Find(&animes).Error; err != nil {
+		return nil, err
+	}
+
+	for _, anime := range animes {
+		suggestions = append(suggestions, AnimeSuggestion{
+			ID:         anime.ID,
+			Title:      anime.Title,
+			CoverImage: anime.CoverImage,
+			Year:       anime.SeasonYear,
+		})
+	}
+	if ttl > 0 {
+		suggestionCache.set(query, suggestions, time.Now().Add(ttl))
+	}
+	return suggestions, nil
+}
+
+// boostRecentlyListed moves anime on the user's list ahead of other suggestions,
+// the more recently the entry was updated the further. It returns a new slice, the
+// cached suggestions are shared between requests.
+func boostRecentlyListed(ctx context.Context, userID uint, suggestions []AnimeSuggestion) ([]AnimeSuggestion, error) {
+	if len(suggestions) == 0 {
+		return suggestions, nil
+	}
+
+	ids := make([]int, len(suggestions))
+	for i, suggestion := range suggestions {
+		ids[i] = suggestion.ID
+	}
+	var entries []models.UserAnimeList
+	if err := config.DB.WithContext(ctx).
+		Select("anime_external_id", "updated_at").
+		Where("user_id = ? AND anime_external_id IN ?", userID, ids).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	updatedAt := make(map[int]time.Time, len(entries))
+	for _, entry := range entries {
+		updatedAt[entry.AnimeExternalID] = entry.UpdatedAt
+	}
+	return rankSuggestions(suggestions, updatedAt, time.Now()), nil
+}
+
+// rankSuggestions scores each suggestion by its position in the text ranking and
+// adds a boost for list entries between 0.5 and 1, decaying with the time since
+// the entry was last updated
+func rankSuggestions(suggestions []AnimeSuggestion, updatedAt map[int]time.Time, now time.Time) []AnimeSuggestion {
+	ranked := make([]AnimeSuggestion, len(suggestions))
+	copy(ranked, suggestions)
+	if len(updatedAt) == 0 {
+		return ranked
+	}
+
+	scores := make(map[int]float64, len(ranked))
+	for i := range ranked {
+		score := 1 - float64(i)/float64(len(ranked))
+		if updated, ok := updatedAt[ranked[i].ID]; ok {
+			ranked[i].InList = true
+			age := max(now.Sub(updated), 0)
+			score += 0.5 + 0.5*math.Exp2(-float64(age)/float64(autocompleteRecencyHalfLife))
+		}
+		scores[ranked[i].ID] = score
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i].ID] > scores[ranked[j].ID]
+	})
+	return ranked
+}
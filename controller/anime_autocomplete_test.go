@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const autocompleteQuery = `SELECT "id","title","cover_image","season_year" FROM "anime_caches" WHERE (search_vector @@ to_tsquery('simple', $1) OR lower(title) LIKE $2 OR lower(title_romaji) LIKE $3) AND "anime_caches"."deleted_at" IS NULL ORDER BY (lower(title) LIKE $4 OR lower(title_romaji) LIKE $5) DESC, ts_rank(search_vector, to_tsquery('simple', $6)) DESC, length(title), id LIMIT $7`
+
+// resetSuggestionCache empties the autocomplete cache before and after a test
+func resetSuggestionCache(t testing.TB) {
+	suggestionCache = &autocompleteCache{entries: make(map[string]autocompleteEntry)}
+	t.Cleanup(func() {
+		suggestionCache = &autocompleteCache{entries: make(map[string]autocompleteEntry)}
+	})
+}
+
+// Test suggestions come from a prefix match on the local catalogue and are cached per normalised query
+func TestAutocompleteAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	resetSuggestionCache(t)
+	router := SetupGin()
+	router.GET("/anime/autocomplete", AutocompleteAnime)
+
+	year := 2023
+	mock.ExpectQuery(EscapeQuery(autocompleteQuery)).
+		WithArgs("sousou:* & no:* & fri:*", "sousou no fri%", "sousou no fri%", "sousou no fri%", "sousou no fri%", "sousou:* & no:* & fri:*", autocompleteCandidates).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "cover_image", "season_year"}).
+			AddRow(154587, "Frieren: Beyond Journey's End", "https://img/frieren.jpg", year))
+
+	for _, q := range []string{"Sousou+no+Fri", "sousou++no+fri+"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/anime/autocomplete?q="+q, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "private, max-age=60", w.Header().Get("Cache-Control"))
+		assert.JSONEq(t, `{"data":[{"id":154587,"title":"Frieren: Beyond Journey's End","cover_image":"https://img/frieren.jpg","year":2023}]}`, w.Body.String())
+	}
+	// The second request was answered from the cache
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/autocomplete?q=+", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Test logged-in users get the anime on their list first
+func TestAutocompleteAnimeBoostsListedAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	resetSuggestionCache(t)
+	router := SetupGin()
+	router.GET("/anime/autocomplete", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 7}})
+	}, AutocompleteAnime)
+
+	mock.ExpectQuery(EscapeQuery(autocompleteQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+			AddRow(20, "Naruto").
+			AddRow(1735, "Naruto: Shippuden").
+			AddRow(21, "Boruto"))
+	mock.ExpectQuery(EscapeQuery(`SELECT "anime_external_id","updated_at" FROM "user_anime_lists" WHERE (user_id = $1 AND anime_external_id IN ($2,$3,$4)) AND "user_anime_lists"."deleted_at" IS NULL`)).
+		WithArgs(7, 20, 1735, 21).
+		WillReturnRows(sqlmock.NewRows([]string{"anime_external_id", "updated_at"}).AddRow(1735, time.Now()))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/autocomplete?q=naru&limit=2", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var responseBody struct {
+		Data []AnimeSuggestion `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	if assert.Len(t, responseBody.Data, 2) {
+		assert.Equal(t, 1735, responseBody.Data[0].ID)
+		assert.True(t, responseBody.Data[0].InList)
+		assert.Equal(t, 20, responseBody.Data[1].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The cached order is not changed by the boost
+	cached, ok := suggestionCache.get("naru", time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, 20, cached[0].ID)
+	assert.False(t, cached[1].InList)
+}
+
+func TestRankSuggestions(t *testing.T) {
+	now := time.Now()
+	suggestions := []AnimeSuggestion{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+
+	ranked := rankSuggestions(suggestions, map[int]time.Time{
+		3: now.Add(-365 * 24 * time.Hour),
+		4: now.Add(-time.Hour),
+	}, now)
+
+	// Recently updated entries beat old ones, which still beat anime not on the list
+	ids := []int{}
+	for _, suggestion := range ranked {
+		ids = append(ids, suggestion.ID)
+	}
+	assert.Equal(t, []int{4, 3, 1, 2}, ids)
+	assert.Equal(t, "sousou:* & no:* & fri:*", prefixTSQuery("sousou no fri"))
+	assert.Equal(t, "re:* & zero:*", prefixTSQuery("re:zero"))
+	assert.Equal(t, "", prefixTSQuery("!&|"))
+	assert.Equal(t, `100\%\_`, escapeLike("100%_"))
+}
+
+// BenchmarkAutocompleteAnime measures a search box request answered from the cache,
+// which should take well under the 20ms budget for suggestions
+func BenchmarkAutocompleteAnime(b *testing.B) {
+	resetSuggestionCache(b)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/anime/autocomplete", AutocompleteAnime)
+
+	suggestions := make([]AnimeSuggestion, autocompleteCandidates)
+	for i := range suggestions {
+		suggestions[i] = AnimeSuggestion{ID: i + 1, Title: "Frieren", CoverImage: "https://img/frieren.jpg"}
+	}
+	suggestionCache.set("frieren", suggestions, time.Now().Add(time.Hour))
+
+	req, _ := http.NewRequest(http.MethodGet, "/anime/autocomplete?q=Frieren", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+	b.StopTimer()
+
+	if perOp := b.Elapsed() / time.Duration(b.N); perOp > 20*time.Millisecond {
+		b.Errorf("autocomplete took %v per request, the budget is 20ms", perOp)
+	}
+}
+
+// BenchmarkAutocompleteAnimeUncached measures a search box request that misses the cache,
+// so every request builds the query, runs it against a seeded catalogue and scans the rows
+func BenchmarkAutocompleteAnimeUncached(b *testing.B) {
+	resetSuggestionCache(b)
+	previousTTL := config.AppSettings.Cache.AutocompleteTTL
+	config.AppSettings.Cache.AutocompleteTTL = 0
+	previousDB := config.DB
+	config.DB = seedCatalogueDB(b, 20000)
+	b.Cleanup(func() {
+		config.AppSettings.Cache.AutocompleteTTL = previousTTL
+		config.DB = previousDB
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/anime/autocomplete", AutocompleteAnime)
+
+	queries := []string{"Anime+1", "anime+42", "Anime+777", "anime+1999"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "/anime/autocomplete?q="+queries[i%len(queries)], nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title":"Anime`) {
+			b.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+		}
+	}
+	b.StopTimer()
+
+	if perOp := b.Elapsed() / time.Duration(b.N); perOp > 20*time.Millisecond {
+		b.Errorf("uncached autocomplete took %v per request, the budget is 20ms", perOp)
+	}
+}
+
+// seedCatalogueDB opens a database holding size anime titled "Anime <n>". It only answers
+// the autocomplete query, matching the title prefix the way the title index would.
+func seedCatalogueDB(b *testing.B, size int) *gorm.DB {
+	catalogue := make(catalogueConn, size)
+	for i := range catalogue {
+		year := 1990 + i%35
+		catalogue[i] = models.AnimeCache{ID: i + 1, Title: fmt.Sprintf("Anime %d", i+1), CoverImage: "https://img/cover.jpg", SeasonYear: &year}
+	}
+	sort.Slice(catalogue, func(i, j int) bool {
+		return strings.ToLower(catalogue[i].Title) < strings.ToLower(catalogue[j].Title)
+	})
+
+	sqlDB := sql.OpenDB(catalogue)
+	b.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		b.Fatalf("failed to open gorm db: %v", err)
+	}
+	return db
+}
+
+// catalogueConn is a database connection over anime sorted by lower-case title
+type catalogueConn []models.AnimeCache
+
+func (c catalogueConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c catalogueConn) Driver() driver.Driver                        { return nil }
+func (c catalogueConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("catalogue: prepared statements are not supported")
+}
+func (c catalogueConn) Close() error { return nil }
+func (c catalogueConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("catalogue: transactions are not supported")
+}
+
+// QueryContext answers the autocomplete query, whose second argument is the title
+// pattern and whose last one the limit
+func (c catalogueConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, `FROM "anime_caches"`) || len(args) < 2 {
+		return nil, fmt.Errorf("catalogue: unexpected query %s", query)
+	}
+	pattern, _ := args[1].Value.(string)
+	limit, _ := args[len(args)-1].Value.(int64)
+	prefix := strings.TrimSuffix(pattern, "%")
+
+	start := sort.Search(len(c), func(i int) bool { return strings.ToLower(c[i].Title) >= prefix })
+	end := start
+	for end < len(c) && int64(end-start) < limit && strings.HasPrefix(strings.ToLower(c[end].Title), prefix) {
+		end++
+	}
+	return &catalogueRows{anime: c[start:end]}, nil
+}
+
+// catalogueRows are the id, title, cover_image and season_year of the matching anime
+type catalogueRows struct {
+	anime []models.AnimeCache
+}
+
+func (r *catalogueRows) Columns() []string {
+	return []string{"id", "title", "cover_image", "season_year"}
+}
+func (r *catalogueRows) Close() error { return nil }
+func (r *catalogueRows) Next(dest []driver.Value) error {
+	if len(r.anime) == 0 {
+		return io.EOF
+	}
+	anime := r.anime[0]
+	r.anime = r.anime[1:]
+	dest[0], dest[1], dest[2], dest[3] = int64(anime.ID), anime.Title, anime.CoverImage, int64(*anime.SeasonYear)
+	return nil
+}
+
+// BenchmarkRankSuggestions measures boosting a full candidate list for a logged-in user
+func BenchmarkRankSuggestions(b *testing.B) {
+	now := time.Now()
+	suggestions := make([]AnimeSuggestion, autocompleteCandidates)
+	updatedAt := make(map[int]time.Time)
+	for i := range suggestions {
+		suggestions[i] = AnimeSuggestion{ID: i + 1}
+		if i%3 == 0 {
+			updatedAt[i+1] = now.Add(-time.Duration(i) * 24 * time.Hour)
+		}
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rankSuggestions(suggestions, updatedAt, now)
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/validation"
+)
+
+const (
+	maxBrowseListValues = 10 // Values per list parameter, e.g. genres
+	maxBrowseValueLen   = 100
+)
+
+// browseQuery holds the query parameters of BrowseAnime. List parameters may be
+// repeated (?genre=Action&genre=Drama) or comma separated (?genre=Action,Drama).
+type browseQuery struct {
+	Genres        []string `form:"genre"`
+	ExcludeGenres []string `form:"exclude_genre"`
+	Tags          []string `form:"tag"`
+	Formats       []string `form:"format"`
+	Statuses      []string `form:"status"`
+	YearFrom      int      `form:"year_from" binding:"omitempty,min=1900,max=2100"`
+	YearTo        int      `form:"year_to" binding:"omitempty,min=1900,max=2100"`
+	MinScore      int      `form:"min_score" binding:"min=0,max=100"`
+	MinEpisodes   int      `form:"episodes_min" binding:"min=0,max=10000"`
+	MaxEpisodes   int      `form:"episodes_max" binding:"min=0,max=10000"`
+	Studio        string   `form:"studio" binding:"max=100"`
+	Adult         bool     `form:"adult"`
+	Sort          string   `form:"sort"`
+}
+
+// normalize splits comma separated lists and upper-cases the AniList enums
+func (q *browseQuery) normalize() {
+	q.Genres = splitList(q.Genres)
+	q.ExcludeGenres = splitList(q.ExcludeGenres)
+	q.Tags = splitList(q.Tags)
+	q.Formats = splitList(q.Formats)
+	q.Statuses = splitList(q.Statuses)
+	for i := range q.Formats {
+		q.Formats[i] = strings.ToUpper(q.Formats[i])
+	}
+	for i := range q.Statuses {
+		q.Statuses[i] = strings.ToUpper(q.Statuses[i])
+	}
+	q.Sort = strings.ToUpper(strings.TrimSpace(q.Sort))
+	q.Studio = strings.TrimSpace(q.Studio)
+}
+
+// check reports the problems the binding rules cannot express
+func (q *browseQuery) check() []validation.FieldError {
+	var fields []validation.FieldError
+	fields = append(fields, checkList("genre", q.Genres, nil)...)
+	fields = append(fields, checkList("exclude_genre", q.ExcludeGenres, nil)...)
+	fields = append(fields, checkList("tag", q.Tags, nil)...)
+	fields = append(fields, checkList("format", q.Formats, api.MediaFormats)...)
+	fields = append(fields, checkList("status", q.Statuses, api.MediaStatuses)...)
+
+	if q.Sort != "" && !slices.Contains(api.MediaSorts, q.Sort) {
+		fields = append(fields, oneOfError("sort", api.MediaSorts))
+	}
+	for _, genre := range q.Genres {
+		if slices.Contains(q.ExcludeGenres, genre) {
+			fields = append(fields, validation.FieldError{Field: "exclude_genre", Code: "excluded_with", Message: "must not repeat a genre from genre"})
+			break
+		}
+	}
+	if q.YearFrom > 0 && q.YearTo > 0 && q.YearTo < q.YearFrom {
+		fields = append(fields, validation.FieldError{Field: "year_to", Code: "gtefield", Message: "must not be less than year_from"})
+	}
+	if q.MaxEpisodes > 0 && q.MaxEpisodes < q.MinEpisodes {
+		fields = append(fields, validation.FieldError{Field: "episodes_max", Code: "gtefield", Message: "must not be less than episodes_min"})
+	}
+	return fields
+}
+
+// filter converts the checked parameters into the AniList filter
+func (q *browseQuery) filter() api.BrowseFilter {
+	return api.BrowseFilter{
+		Genres:        q.Genres,
+		ExcludeGenres: q.ExcludeGenres,
+		Tags:          q.Tags,
+		Formats:       q.Formats,
+		Statuses:      q.Statuses,
+		YearFrom:      q.YearFrom,
+		YearTo:        q.YearTo,
+		MinScore:      q.MinScore,
+		MinEpisodes:   q.MinEpisodes,
+		MaxEpisodes:   q.MaxEpisodes,
+		Studio:        q.Studio,
+		IncludeAdult:  q.Adult,
+		Sort:          q.Sort,
+	}
+}
+
+// splitList splits comma separated values and drops empty ones
+func splitList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// checkList limits the number and length of values, and restricts them to allowed unless it is nil
+func checkList(field string, values []string, allowed []string) []validation.FieldError {
+	if len(values) > maxBrowseListValues {
+		return []validation.FieldError{{Field: field, Code: "max", Message: "must have at most 10 values"}}
+	}
+	for _, value := range values {
+		if len(value) > maxBrowseValueLen {
+			return []validation.FieldError{{Field: field, Code: "max", Message: "values must be at most 100 characters"}}
+		}
+		if allowed != nil && !slices.Contains(allowed, value) {
+			return []validation.FieldError{oneOfError(field, allowed)}
+		}
+	}
+	return nil
+}
+
+func oneOfError(field string, allowed []string) validation.FieldError {
+	return validation.FieldError{Field: field, Code: "oneof", Message: "must be one of: " + strings.Join(allowed, " ")}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// Test the query parameters are normalised into the AniList filter
+func TestBrowseAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	filter := api.BrowseFilter{
+		Genres:        []string{"Action", "Drama"},
+		ExcludeGenres: []string{"Romance"},
+		Formats:       []string{"TV", "MOVIE"},
+		Statuses:      []string{"FINISHED"},
+		YearFrom:      2010,
+		YearTo:        2020,
+		MinScore:      75,
+		Studio:        "Wit Studio",
+		Sort:          "SCORE_DESC",
+	}
+	mockAPI.On("BrowseAnime", filter, 2, 10).Return(api.BrowseResult{Anime: []models.AnimeCache{{ID: 16498, Title: "Attack on Titan"}}, Total: 11}, nil)
+	expectStoreAnime(mock, 16498)
+
+	router.GET("/anime/browse", BrowseAnime)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/browse?genre=Action,Drama&exclude_genre=Romance&format=tv&format=movie"+
+		"&status=finished&year_from=2010&year_to=2020&min_score=75&studio=Wit+Studio&sort=score_desc&page=2&perPage=10", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"hasNextPage":false`)
+	assert.Contains(t, w.Body.String(), `"truncated":false`)
+	assert.Contains(t, w.Body.String(), `"title":"Attack on Titan"`)
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test invalid filters are rejected before AniList is asked
+func TestBrowseAnimeValidation(t *testing.T) {
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+	router.GET("/anime/browse", BrowseAnime)
+
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{name: "unknown format", query: "format=TV,BOOK", field: "format"},
+		{name: "unknown status", query: "status=AIRING", field: "status"},
+		{name: "unknown sort", query: "sort=RANDOM", field: "sort"},
+		{name: "score out of range", query: "min_score=101", field: "min_score"},
+		{name: "year range reversed", query: "year_from=2020&year_to=2010", field: "year_to"},
+		{name: "episode range reversed", query: "episodes_min=24&episodes_max=12", field: "episodes_max"},
+		{name: "genre included and excluded", query: "genre=Action&exclude_genre=Action", field: "exclude_genre"},
+		{name: "too many tags", query: "tag=a,b,c,d,e,f,g,h,i,j,k", field: "tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/anime/browse?"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), `"field":"`+tt.field+`"`)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/browse?year_from=soon", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockAPI.AssertNotCalled(t, "BrowseAnime")
+}
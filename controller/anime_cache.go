@@ -0,0 +1,409 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CacheStatusHeader tells clients where anime details came from, in the format of RFC 9211
+const CacheStatusHeader = "Cache-Status"
+
+// refreshTimeout bounds background refreshes, which outlive the request that started them
+const refreshTimeout = 30 * time.Second
+
+// cacheResult describes how a details lookup was served
+type cacheResult struct {
+	hit    bool          // Served from the database
+	fwd    string        // Why AniList was asked instead: "uri-miss", "stale" or "partial"
+	ttl    time.Duration // Remaining freshness of a hit; negative when stale
+	detail string        // Why stale details were served
+}
+
+// Header renders the result as a Cache-Status value
+func (r cacheResult) Header() string {
+	if !r.hit {
+		return "wawatch; fwd=" + r.fwd + "; stored"
+	}
+	value := fmt.Sprintf("wawatch; hit; ttl=%d", int(r.ttl.Seconds()))
+	if r.detail != "" {
+		value += "; detail=" + r.detail
+	}
+	return value
+}
+
+// detailsTTL is how long details with the given AniList status stay fresh
+func detailsTTL(status string) time.Duration {
+	settings := config.AppSettings.Cache
+	switch status {
+	case "RELEASING", "NOT_YET_RELEASED":
+		return settings.ReleasingTTL
+	case "FINISHED", "CANCELLED":
+		return settings.FinishedTTL
+	default:
+		return settings.DefaultTTL
+	}
+}
+
+// refreshing holds the IDs with a background refresh in progress, so a popular
+// stale entry is refreshed once instead of by every request that sees it
+var refreshing = struct {
+	sync.Mutex
+	ids map[int]bool
+}{ids: map[int]bool{}}
+
+// getAnimeDetails serves details from the cache while they are fresh. Expired details are
+// still served during the stale-while-revalidate window and refreshed in the background;
+// after that they are fetched again, falling back to the stale copy if AniList fails.
+// With extended set, details cached without the extended sections are fetched again.
+func getAnimeDetails(ctx context.Context, id int, extended bool) (*models.AnimeDetails, cacheResult, error) {
+	var cached models.AnimeCache
+	err := config.DB.WithContext(ctx).Where("id = ?", id).First(&cached).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Failed to read anime %d from cache: %v", id, err)
+	}
+	if err != nil || cached.Details == nil || cached.LastFetchedAt == nil {
+		details, err := fetchAnimeDetails(ctx, id)
+		return details, cacheResult{fwd: "uri-miss"}, err
+	}
+	if extended && !cached.Details.HasExtendedSections() {
+		details, err := fetchAnimeDetails(ctx, id)
+		return details, cacheResult{fwd: "partial"}, err
+	}
+
+	ttl := detailsTTL(cached.Status) - time.Since(*cached.LastFetchedAt)
+	if ttl > 0 {
+		return cached.Details, cacheResult{hit: true, ttl: ttl}, nil
+	}
+	if -ttl < config.AppSettings.Cache.StaleWhileRevalidate {
+		refreshInBackground(id)
+		return cached.Details, cacheResult{hit: true, ttl: ttl, detail: "stale-while-revalidate"}, nil
+	}
+
+	details, err := fetchAnimeDetails(ctx, id)
+	if err != nil {
+		if errors.Is(err, api.ErrNotFound) || ctx.Err() != nil {
+			return nil, cacheResult{}, err
+		}
+		log.Printf("Serving stale details of anime %d: %v", id, err)
+		return cached.Details, cacheResult{hit: true, ttl: ttl, detail: "stale-if-error"}, nil
+	}
+	return details, cacheResult{fwd: "stale"}, nil
+}
+
+// fetchAnimeDetails gets the details from AniList and stores them in the cache
+func fetchAnimeDetails(ctx context.Context, id int) (*models.AnimeDetails, error) {
+	details, err := anilistClient.GetAnimeDetailsByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeAnimeDetails(ctx, details); err != nil {
+		// The details are still good to serve
+		log.Printf("Failed to cache anime %d: %v", id, err)
+	}
+	return details, nil
+}
+
+// catalogueColumns are the anime_caches columns filled from any AniList result
+var catalogueColumns = []string{
+	"updated_at", "deleted_at", "title", "title_romaji", "title_english", "title_native", "synonyms",
+	"cover_image", "format", "total_episodes", "status", "season", "season_year",
+}
+
+// storeAnimeDetails inserts or updates the cache entries of the anime, including their full details
+func storeAnimeDetails(ctx context.Context, details ...*models.AnimeDetails) error {
+	now := time.Now()
+	entries := make([]models.AnimeCache, len(details))
+	for i, anime := range details {
+		entries[i] = anime.ToAnimeCache()
+		entries[i].Details = anime
+		entries[i].LastFetchedAt = &now
+	}
+	return storeAnimeCaches(ctx, entries, append(catalogueColumns, "details", "last_fetched_at"))
+}
+
+// storeAnimeSummaries adds search results and other pages to the local catalogue.
+// Cached details are kept; they are refreshed by their own TTL.
+func storeAnimeSummaries(ctx context.Context, entries []models.AnimeCache) {
+	if err := storeAnimeCaches(ctx, entries, catalogueColumns); err != nil {
+		log.Printf("Failed to store %d anime in the catalogue: %v", len(entries), err)
+	}
+}
+
+// storeAnimeCaches upserts the entries, updating the given columns of existing ones,
+// and replaces their genre and studio links
+func storeAnimeCaches(ctx context.Context, entries []models.AnimeCache, columns []string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).Create(&entries).Error; err != nil {
+			return err
+		}
+		return linkGenresAndStudios(tx, entries)
+	})
+}
+
+// linkGenresAndStudios replaces the anime_genres and anime_studios rows of the entries,
+// creating genres and studios that are not known yet
+func linkGenresAndStudios(tx *gorm.DB, entries []models.AnimeCache) error {
+	ids := make([]int, len(entries))
+	var genres []models.Genre
+	var studios []models.Studio
+	for i, entry := range entries {
+		ids[i] = entry.ID
+		genres = append(genres, entry.Genres...)
+		studios = append(studios, entry.Studios...)
+	}
+
+	genreIDs, err := upsertGenres(tx, genres)
+	if err != nil {
+		return err
+	}
+	studioIDs, err := upsertStudios(tx, studios)
+	if err != nil {
+		return err
+	}
+
+	var animeGenres []models.AnimeGenre
+	var animeStudios []models.AnimeStudio
+	for _, entry := range entries {
+		seen := map[uint]bool{}
+		for _, genre := range entry.Genres {
+			if id := genreIDs[genre.Name]; !seen[id] {
+				animeGenres = append(animeGenres, models.AnimeGenre{AnimeID: entry.ID, GenreID: id})
+				seen[id] = true
+			}
+		}
+		seen = map[uint]bool{}
+		for _, studio := range entry.Studios {
+			if id := studioIDs[studio.Name]; !seen[id] {
+				animeStudios = append(animeStudios, models.AnimeStudio{AnimeID: entry.ID, StudioID: id})
+				seen[id] = true
+			}
+		}
+	}
+
+	if err := tx.Where("anime_id IN ?", ids).Delete(&models.AnimeGenre{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("anime_id IN ?", ids).Delete(&models.AnimeStudio{}).Error; err != nil {
+		return err
+	}
+	if len(animeGenres) > 0 {
+		if err := tx.Create(&animeGenres).Error; err != nil {
+			return err
+		}
+	}
+	if len(animeStudios) > 0 {
+		if err := tx.Create(&animeStudios).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertGenres makes sure the genres exist and returns their IDs by name
+func upsertGenres(tx *gorm.DB, genres []models.Genre) (map[string]uint, error) {
+	ids := map[string]uint{}
+	var unique []models.Genre
+	for _, genre := range genres {
+		if _, ok := ids[genre.Name]; !ok {
+			ids[genre.Name] = 0
+			unique = append(unique, models.Genre{Name: genre.Name})
+		}
+	}
+	if len(unique) == 0 {
+		return ids, nil
+	}
+
+	// Updating the name to itself makes RETURNING include existing rows
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+	}).Create(&unique).Error; err != nil {
+		return nil, err
+	}
+	for _, genre := range unique {
+		ids[genre.Name] = genre.ID
+	}
+	return ids, nil
+}
+
+// upsertStudios makes sure the studios exist and returns their IDs by name
+func upsertStudios(tx *gorm.DB, studios []models.Studio) (map[string]uint, error) {
+	ids := map[string]uint{}
+	var unique []models.Studio
+	for _, studio := range studios {
+		if _, ok := ids[studio.Name]; !ok {
+			ids[studio.Name] = 0
+			unique = append(unique, models.Studio{Name: studio.Name})
+		}
+	}
+	if len(unique) == 0 {
+		return ids, nil
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name"}),
+	}).Create(&unique).Error; err != nil {
+		return nil, err
+	}
+	for _, studio := range unique {
+		ids[studio.Name] = studio.ID
+	}
+	return ids, nil
+}
+
+// loadAnimeCaches returns the cache entries of the given anime by ID. Anime missing from
+// the cache are fetched from AniList in batches; if that fails they are left out.
+func loadAnimeCaches(ctx context.Context, ids []int) (map[int]models.AnimeCache, error) {
+	caches := make(map[int]models.AnimeCache, len(ids))
+	if len(ids) == 0 {
+		return caches, nil
+	}
+
+	var entries []models.AnimeCache
+	if err := config.DB.WithContext(ctx).Where("id IN ?", ids).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		caches[entry.ID] = entry
+	}
+
+	var missing []int
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := caches[id]; !ok && !seen[id] {
+			missing = append(missing, id)
+		}
+		seen[id] = true
+	}
+	if len(missing) == 0 {
+		return caches, nil
+	}
+
+	fetched, err := anilistClient.GetAnimeByIDs(ctx, missing)
+	if err != nil {
+		log.Printf("Failed to fetch %d uncached anime: %v", len(missing), err)
+		return caches, nil
+	}
+	details := make([]*models.AnimeDetails, len(fetched))
+	for i := range fetched {
+		details[i] = &fetched[i]
+		entry := fetched[i].ToAnimeCache()
+		entry.Details = details[i]
+		caches[entry.ID] = entry
+	}
+	if err := storeAnimeDetails(ctx, details...); err != nil {
+		log.Printf("Failed to cache %d anime: %v", len(details), err)
+	}
+	return caches, nil
+}
+
+// refreshInBackground refetches the details without holding up the current request
+func refreshInBackground(id int) {
+	refreshing.Lock()
+	if refreshing.ids[id] {
+		refreshing.Unlock()
+		return
+	}
+	refreshing.ids[id] = true
+	refreshing.Unlock()
+
+	done := func() {
+		refreshing.Lock()
+		delete(refreshing.ids, id)
+		refreshing.Unlock()
+	}
+	started := goBackground(refreshTimeout, func(ctx context.Context) {
+		defer done()
+		if _, err := fetchAnimeDetails(ctx, id); err != nil {
+			log.Printf("Failed to refresh anime %d: %v", id, err)
+		}
+	})
+	if !started {
+		done()
+	}
+}
+
+// GetAniListStats reports how AniList queries were served, including how many were coalesced (admin only)
+func GetAniListStats(c *gin.Context) {
+	reporter, ok := anilistClient.(api.StatsReporter)
+	if !ok {
+		c.Error(apperr.NotFound("The AniList client keeps no stats"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"anilist": reporter.Stats()})
+}
+
+// refreshBatchSize is how many anime are refetched per AniList request and stored at once
+const refreshBatchSize = 50
+
+// RefreshAiringAnime refetches anime on users' lists that are airing or not yet released,
+// so episode counts stay current; entries cached before statuses were stored are included.
+// It is run periodically by the worker, AniList's rate limit is kept by the client.
+func RefreshAiringAnime(ctx context.Context) error {
+	var ids []int
+	listed := config.DB.Model(&models.UserAnimeList{}).Select("anime_external_id")
+	if err := config.DB.WithContext(ctx).Model(&models.AnimeCache{}).
+		Where("status IN ? OR status IS NULL OR status = ''", []string{"RELEASING", "NOT_YET_RELEASED"}).
+		Where("id IN (?)", listed).
+		Order("id").
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	refreshed := 0
+	for start := 0; start < len(ids); start += refreshBatchSize {
+		chunk := ids[start:min(start+refreshBatchSize, len(ids))]
+		fetched, err := anilistClient.GetAnimeByIDs(ctx, chunk)
+		if err != nil {
+			return fmt.Errorf("refreshed %d of %d anime: %w", refreshed, len(ids), err)
+		}
+
+		// The batch query leaves out the extended sections, keep the cached ones
+		var cached []models.AnimeCache
+		if err := config.DB.WithContext(ctx).Select("id", "details").Where("id IN ?", chunk).Find(&cached).Error; err != nil {
+			return fmt.Errorf("refreshed %d of %d anime: %w", refreshed, len(ids), err)
+		}
+		previous := make(map[int]*models.AnimeDetails, len(cached))
+		for _, entry := range cached {
+			if entry.Details != nil && entry.Details.HasExtendedSections() {
+				previous[entry.ID] = entry.Details
+			}
+		}
+
+		details := make([]*models.AnimeDetails, len(fetched))
+		for i := range fetched {
+			details[i] = &fetched[i]
+			if old, ok := previous[fetched[i].ID]; ok {
+				details[i].CopyExtendedSections(old)
+			}
+		}
+		if err := storeAnimeDetails(ctx, details...); err != nil {
+			return fmt.Errorf("refreshed %d of %d anime: %w", refreshed, len(ids), err)
+		}
+		refreshed += len(details)
+	}
+
+	log.Printf("Refreshed %d of %d airing anime", refreshed, len(ids))
+	return nil
+}
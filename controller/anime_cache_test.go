@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+var animeCacheColumns = []string{"id", "title", "status", "details", "last_fetched_at"}
+
+func cachedDetails(t *testing.T, id int, status string) []byte {
+	details := models.AnimeDetails{ID: id, Status: status}
+	details.Title.English = "Cached Anime"
+	data, err := json.Marshal(details)
+	assert.NoError(t, err)
+	return data
+}
+
+func expectAnimeCacheRow(mock sqlmock.Sqlmock, id int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE id = $1 AND "anime_caches"."deleted_at" IS NULL`)).
+		WithArgs(id, 1).
+		WillReturnRows(rows)
+}
+
+func expectProviders(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "watch_providers" WHERE anime_id = $1`)).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectStoreAnime expects the anime to be upserted, for anime without genres or studios
+func expectStoreAnime(mock sqlmock.Sqlmock, ids ...int) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "anime_caches"`) + `.*ON CONFLICT \("id"\) DO UPDATE`).
+		WillReturnRows(rows)
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "anime_genres" WHERE anime_id IN`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "anime_studios" WHERE anime_id IN`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+// Test fresh cached details are served without asking AniList
+func TestGetAnimeDetailsFromCache(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	fetched := time.Now().Add(-time.Minute)
+	expectAnimeCacheRow(mock, 21, sqlmock.NewRows(animeCacheColumns).
+		AddRow(21, "Cached Anime", "FINISHED", cachedDetails(t, 21, "FINISHED"), fetched))
+	expectProviders(mock, 21)
+
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/21", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get(CacheStatusHeader), "wawatch; hit; ttl="))
+	assert.Contains(t, w.Body.String(), "Cached Anime")
+	mockAPI.AssertNotCalled(t, "GetAnimeDetailsByID", 21)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a missing entry is fetched from AniList and stored
+func TestGetAnimeDetailsCacheMiss(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	details := &models.AnimeDetails{ID: 22, Status: "RELEASING"}
+	details.Title.English = "Fresh Anime"
+	mockAPI.On("GetAnimeDetailsByID", 22).Return(details, nil)
+
+	expectAnimeCacheRow(mock, 22, sqlmock.NewRows(animeCacheColumns))
+	expectStoreAnime(mock, 22)
+	expectProviders(mock, 22)
+
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/22", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "wawatch; fwd=uri-miss; stored", w.Header().Get(CacheStatusHeader))
+	assert.Contains(t, w.Body.String(), "Fresh Anime")
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test details past the revalidation window are still served when AniList is down
+func TestGetAnimeDetailsStaleIfError(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mockAPI.On("GetAnimeDetailsByID", 23).Return(nil, errors.New("anilist API returned status 502"))
+
+	fetched := time.Now().Add(-365 * 24 * time.Hour)
+	expectAnimeCacheRow(mock, 23, sqlmock.NewRows(animeCacheColumns).
+		AddRow(23, "Cached Anime", "RELEASING", cachedDetails(t, 23, "RELEASING"), fetched))
+	expectProviders(mock, 23)
+
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/23", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get(CacheStatusHeader), "detail=stale-if-error")
+	assert.Contains(t, w.Body.String(), "Cached Anime")
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the refresh job refetches the airing anime on users' lists in one batch,
+// keeping the extended sections of the cached details
+func TestRefreshAiringAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+
+	mock.ExpectQuery(EscapeQuery(`SELECT "id" FROM "anime_caches" WHERE (status IN ($1,$2) OR status IS NULL OR status = '') AND id IN (SELECT "anime_external_id" FROM "user_anime_lists" WHERE "user_anime_lists"."deleted_at" IS NULL) AND "anime_caches"."deleted_at" IS NULL ORDER BY id`)).
+		WithArgs("RELEASING", "NOT_YET_RELEASED").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21).AddRow(22))
+
+	fetched := []models.AnimeDetails{{ID: 21, Status: "RELEASING", Episodes: 1100}, {ID: 22, Status: "FINISHED"}}
+	mockAPI.On("GetAnimeByIDs", []int{21, 22}).Return(fetched, nil)
+
+	// Anime 21 was cached with the extended sections, which the batch query leaves out
+	extended, _ := json.Marshal(models.AnimeDetails{ID: 21, Characters: &models.CharacterConnection{}, Tags: []models.MediaTag{{Name: "Pirates"}}})
+	mock.ExpectQuery(EscapeQuery(`SELECT "id","details" FROM "anime_caches" WHERE id IN ($1,$2)`)).
+		WithArgs(21, 22).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "details"}).AddRow(21, extended).AddRow(22, cachedDetails(t, 22, "RELEASING")))
+	expectStoreAnime(mock, 21, 22)
+
+	assert.NoError(t, RefreshAiringAnime(context.Background()))
+	assert.True(t, fetched[0].HasExtendedSections())
+	assert.Equal(t, "Pirates", fetched[0].Tags[0].Name)
+	assert.False(t, fetched[1].HasExtendedSections())
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test stored anime are linked to their genres and studios, which are created by name
+func TestStoreAnimeDetailsLinksGenresAndStudios(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+
+	details := &models.AnimeDetails{ID: 21, Genres: []string{"Action", "Adventure", "Action"}}
+	details.Title.Romaji = "One Piece"
+	details.Studios.Nodes = append(details.Studios.Nodes, struct {
+		Name string `json:"name"`
+	}{Name: "Toei Animation"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "anime_caches"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "genres" ("name") VALUES ($1),($2) ON CONFLICT ("name") DO UPDATE SET "name"="excluded"."name" RETURNING "id"`)).
+		WithArgs("Action", "Adventure").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "studios" ("name") VALUES ($1) ON CONFLICT ("name") DO UPDATE SET "name"="excluded"."name" RETURNING "id"`)).
+		WithArgs("Toei Animation").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "anime_genres" WHERE anime_id IN ($1)`)).
+		WithArgs(21).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "anime_studios" WHERE anime_id IN ($1)`)).
+		WithArgs(21).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(EscapeQuery(`INSERT INTO "anime_genres" ("anime_id","genre_id") VALUES ($1,$2),($3,$4)`)).
+		WithArgs(21, 1, 21, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(EscapeQuery(`INSERT INTO "anime_studios" ("anime_id","studio_id") VALUES ($1,$2)`)).
+		WithArgs(21, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, storeAnimeDetails(context.Background(), details))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,12 +1,16 @@
 package controller
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/apperr"
 	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
 )
@@ -24,26 +28,110 @@ func SetAniListClient(client api.AniListAPI) {
 
 // Initialize with the real client by default when the package loads.
 func init() {
-	SetAniListClient(api.NewAniListClient())
+	SetAniListClient(api.NewAniListClient(config.AppSettings.AniList))
 }
 
-// SearchAnime handles anime search requests
+// anilistError reports a failed AniList call. Missing media is a 404;
+// AniList's rate limit is temporary, so clients are told to try again later.
+func anilistError(message string, err error) *apperr.Error {
+	if errors.Is(err, api.ErrNotFound) {
+		return apperr.NotFound("Anime not found")
+	}
+	if errors.Is(err, api.ErrRateLimited) {
+		return apperr.Unavailable(message+", AniList is busy. Please try again later", err)
+	}
+	return apperr.Upstream(message, err)
+}
+
+// SearchAnime handles anime search requests. The source parameter picks AniList ("remote"),
+// the local catalogue ("local") or AniList with the local catalogue as fallback ("auto").
+// Searching AniList adds its results to the catalogue, so it requires a logged-in user;
+// the default is "auto" for them and "local" for everyone else.
 func SearchAnime(c *gin.Context) {
 	query := c.Query("q")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		c.Error(apperr.BadRequest("Search query is required"))
 		return
 	}
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "20"))
+	page = max(page, 1)
+	perPage = min(max(perPage, 1), 50)
 
-	results, total, err := anilistClient.SearchAnime(query, page, perPage)
+	_, loggedIn := c.Get("user")
+	defaultSource := SearchSourceLocal
+	if loggedIn {
+		defaultSource = SearchSourceAuto
+	}
+	source := c.DefaultQuery("source", defaultSource)
+	if source != SearchSourceLocal && source != SearchSourceRemote && source != SearchSourceAuto {
+		c.Error(apperr.BadRequest("Invalid source. Use local, remote or auto"))
+		return
+	}
+	if source != SearchSourceLocal && !loggedIn {
+		c.Error(apperr.Unauthorized("Log in to search AniList"))
+		return
+	}
+
+	if source != SearchSourceLocal {
+		results, total, err := anilistClient.SearchAnime(c.Request.Context(), query, page, perPage)
+		if err == nil {
+			storeAnimeSummaries(c.Request.Context(), results)
+			respondSearchResults(c, results, total, page, perPage, SearchSourceRemote)
+			return
+		}
+		if source == SearchSourceRemote || c.Request.Context().Err() != nil {
+			c.Error(anilistError("Failed to search anime", err))
+			return
+		}
+		log.Printf("AniList search failed, searching the local catalogue: %v", err)
+	}
+
+	results, total, err := searchLocalAnime(c.Request.Context(), query, page, perPage)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search anime: " + err.Error()})
+		c.Error(apperr.Internal("Failed to search anime", err))
+		return
+	}
+	respondSearchResults(c, results, total, page, perPage, SearchSourceLocal)
+}
+
+// AutocompleteAnime suggests anime from the local catalogue while the user types.
+// Logged-in users see the anime on their own list first.
+func AutocompleteAnime(c *gin.Context) {
+	query := normalizeAutocompleteQuery(c.Query("q"))
+	if query == "" {
+		c.Error(apperr.BadRequest("Search query is required"))
 		return
 	}
 
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	limit = min(max(limit, 1), 20)
+
+	suggestions, err := animeSuggestions(c.Request.Context(), query)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to suggest anime", err))
+		return
+	}
+
+	if userInterface, exists := c.Get("user"); exists {
+		user := userInterface.(models.User)
+		suggestions, err = boostRecentlyListed(c.Request.Context(), user.ID, suggestions)
+		if err != nil {
+			c.Error(apperr.Internal("Failed to suggest anime", err))
+			return
+		}
+	}
+
+	// Suggestions depend on the user, shared caches must not keep them
+	if ttl := config.AppSettings.Cache.AutocompleteTTL; ttl > 0 {
+		c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(ttl.Seconds())))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": suggestions[:min(limit, len(suggestions))]})
+}
+
+// respondSearchResults renders a page of search results and where they came from
+func respondSearchResults(c *gin.Context, results []models.AnimeCache, total, page, perPage int, source string) {
 	c.JSON(http.StatusOK, gin.H{
 		"data": results,
 		"meta": gin.H{
@@ -52,35 +140,35 @@ func SearchAnime(c *gin.Context) {
 			"perPage":     perPage,
 			"totalPages":  (total + perPage - 1) / perPage,
 			"hasNextPage": page*perPage < total,
+			"source":      source,
 		},
 	})
 }
 
-// GetAnimeDetails fetches detailed information about an anime
+// GetAnimeDetails fetches detailed information about an anime. Tags, characters, staff,
+// relations, the trailer, external links and the next airing episode are only
+// returned when listed in the include parameter.
 func GetAnimeDetails(c *gin.Context) {
 	idParam := c.Param("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid anime ID"})
+		c.Error(apperr.BadRequest("Invalid anime ID"))
 		return
 	}
 
-	// First, check if we have this in cache
-	var cache models.AnimeCache
-	if err := config.DB.First(&cache, id).Error; err == nil {
-		// We have it in cache, but still need detailed info
+	include, invalid := parseDetailsInclude(c.Query("include"))
+	if invalid != nil {
+		c.Error(invalid)
+		return
 	}
 
-	// Get detailed info from AniList
-	anime, err := anilistClient.GetAnimeByID(id)
+	details, cached, err := getAnimeDetails(c.Request.Context(), id, len(include) > 0)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch anime details: " + err.Error()})
+		c.Error(anilistError("Failed to fetch anime details", err))
 		return
 	}
-
-	// Update or create cache entry
-	cacheEntry := anime.ToAnimeCache()
-	config.DB.Save(&cacheEntry)
+	anime := withDetailsSections(details, include)
+	c.Header(CacheStatusHeader, cached.Header())
 
 	// Get watch providers
 	var providers []models.WatchProvider
@@ -92,11 +180,49 @@ func GetAnimeDetails(c *gin.Context) {
 	})
 }
 
+// GetAnimeFranchise returns the anime related to an anime by sequels, prequels and side
+// stories, with the orders to watch them in. Logged-in users also get their list status
+// of every anime.
+func GetAnimeFranchise(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.BadRequest("Invalid anime ID"))
+		return
+	}
+
+	franchise, err := anilistClient.GetFranchise(c.Request.Context(), id)
+	if err != nil {
+		c.Error(anilistError("Failed to fetch franchise", err))
+		return
+	}
+
+	var userID uint
+	if userInterface, exists := c.Get("user"); exists {
+		userID = userInterface.(models.User).ID
+	}
+	nodes, err := franchiseNodes(c.Request.Context(), franchise, userID)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to retrieve anime list", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"root_id":   franchise.RootID,
+		"nodes":     nodes,
+		"edges":     franchise.Edges,
+		"truncated": franchise.Truncated,
+		"watch_order": gin.H{
+			"release":       franchise.ReleaseOrder,
+			"chronological": franchise.ChronologicalOrder,
+		},
+	})
+}
+
 // AddWatchProvider adds a new watch provider for an anime
 func AddWatchProvider(c *gin.Context) {
 	var provider models.WatchProvider
 	if err := c.ShouldBindJSON(&provider); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation(err))
 		return
 	}
 
@@ -104,20 +230,22 @@ func AddWatchProvider(c *gin.Context) {
 	var animeCache models.AnimeCache
 	if err := config.DB.First(&animeCache, provider.AnimeID).Error; err != nil {
 		// Fetch from AniList if not in cache
-		anime, err := anilistClient.GetAnimeByID(int(provider.AnimeID))
+		anime, err := anilistClient.GetAnimeByID(c.Request.Context(), int(provider.AnimeID))
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Anime not found"})
+			c.Error(anilistError("Failed to fetch anime details", err))
 			return
 		}
 
-		// Create cache entry
-		cacheEntry := anime.ToAnimeCache()
-		config.DB.Create(&cacheEntry)
+		// Create cache entry, the provider references it
+		if err := storeAnimeDetails(c.Request.Context(), anime); err != nil {
+			c.Error(apperr.Internal("Failed to cache anime", err))
+			return
+		}
 	}
 
 	// Save the provider
 	if err := config.DB.Create(&provider).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider"})
+		c.Error(apperr.Internal("Failed to save provider", err))
 		return
 	}
 
@@ -129,11 +257,12 @@ func GetPopularAnime(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "20"))
 
-	results, total, err := anilistClient.GetPopularAnime(page, perPage) // Needs implementation in api/anilist.go
+	results, total, err := anilistClient.GetPopularAnime(c.Request.Context(), page, perPage) // Needs implementation in api/anilist.go
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch popular anime: " + err.Error()})
+		c.Error(anilistError("Failed to fetch popular anime", err))
 		return
 	}
+	storeAnimeSummaries(c.Request.Context(), results)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": results,
@@ -152,11 +281,12 @@ func GetTrendingAnime(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "20"))
 
-	results, total, err := anilistClient.GetTrendingAnime(page, perPage) // Needs implementation in api/anilist.go
+	results, total, err := anilistClient.GetTrendingAnime(c.Request.Context(), page, perPage) // Needs implementation in api/anilist.go
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending anime: " + err.Error()})
+		c.Error(anilistError("Failed to fetch trending anime", err))
 		return
 	}
+	storeAnimeSummaries(c.Request.Context(), results)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": results,
@@ -177,24 +307,25 @@ func GetAnimeBySeason(c *gin.Context) {
 
 	year, err := strconv.Atoi(yearParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year format"})
+		c.Error(apperr.BadRequest("Invalid year format"))
 		return
 	}
 
 	validSeasons := map[string]bool{"WINTER": true, "SPRING": true, "SUMMER": true, "FALL": true}
 	if !validSeasons[seasonParam] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid season. Use WINTER, SPRING, SUMMER, or FALL"})
+		c.Error(apperr.BadRequest("Invalid season. Use WINTER, SPRING, SUMMER, or FALL"))
 		return
 	}
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "20"))
 
-	results, total, err := anilistClient.GetAnimeBySeason(year, seasonParam, page, perPage) // Needs implementation in api/anilist.go
+	results, total, err := anilistClient.GetAnimeBySeason(c.Request.Context(), year, seasonParam, page, perPage) // Needs implementation in api/anilist.go
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch anime by season: " + err.Error()})
+		c.Error(anilistError("Failed to fetch anime by season", err))
 		return
 	}
+	storeAnimeSummaries(c.Request.Context(), results)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": results,
@@ -208,6 +339,51 @@ func GetAnimeBySeason(c *gin.Context) {
 	})
 }
 
+// BrowseAnime lists anime from AniList filtered by genres, tags, format, status,
+// start year, score, episode count and studio, in the requested order
+func BrowseAnime(c *gin.Context) {
+	var query browseQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		var validationErrs validator.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			c.Error(apperr.BadRequest("Invalid query parameters"))
+			return
+		}
+		c.Error(apperr.Validation(err))
+		return
+	}
+	query.normalize()
+	if fields := query.check(); len(fields) > 0 {
+		c.Error(apperr.InvalidFields(fields...))
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "20"))
+	page = max(page, 1)
+	perPage = min(max(perPage, 1), 50)
+
+	result, err := anilistClient.BrowseAnime(c.Request.Context(), query.filter(), page, perPage)
+	if err != nil {
+		c.Error(anilistError("Failed to browse anime", err))
+		return
+	}
+	storeAnimeSummaries(c.Request.Context(), result.Anime)
+
+	total := result.Total
+	c.JSON(http.StatusOK, gin.H{
+		"data": result.Anime,
+		"meta": gin.H{
+			"total":       total,
+			"page":        page,
+			"perPage":     perPage,
+			"totalPages":  (total + perPage - 1) / perPage,
+			"hasNextPage": page*perPage < total,
+			"truncated":   result.Truncated, // Only the studio's first 200 anime were searched
+		},
+	})
+}
+
 // GetAnimeRecommendations fetches recommendations (placeholder, uses popular for now)
 func GetAnimeRecommendations(c *gin.Context) {
 	// _, exists := c.Get("user") // Get user if needed for personalized recommendations
@@ -223,11 +399,12 @@ func GetAnimeRecommendations(c *gin.Context) {
 	// Ideally, call a specific recommendation function in anilistClient
 	// For now, let's reuse popular as a placeholder
 	// results, total, err := anilistClient.GetAnimeRecommendations(userModel.ID, page, perPage)
-	results, total, err := anilistClient.GetPopularAnime(page, perPage) // Placeholder
+	results, total, err := anilistClient.GetPopularAnime(c.Request.Context(), page, perPage) // Placeholder
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recommendations: " + err.Error()})
+		c.Error(anilistError("Failed to fetch recommendations", err))
 		return
 	}
+	storeAnimeSummaries(c.Request.Context(), results)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": results,
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -11,7 +14,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock" // Import api package
+	"github.com/vrstep/wawatch-backend/api"
 	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
 )
 
 // Mock AniList Client (Place this at the top or in a helper)
@@ -19,7 +24,7 @@ type MockAniListClient struct {
 	mock.Mock
 }
 
-func (m *MockAniListClient) GetAnimeByID(id int) (*models.AnimeDetails, error) {
+func (m *MockAniListClient) GetAnimeByID(ctx context.Context, id int) (*models.AnimeDetails, error) {
 	args := m.Called(id)
 	var details *models.AnimeDetails
 	if args.Get(0) != nil {
@@ -28,7 +33,25 @@ func (m *MockAniListClient) GetAnimeByID(id int) (*models.AnimeDetails, error) {
 	return details, args.Error(1)
 }
 
-func (m *MockAniListClient) SearchAnime(query string, page int, perPage int) ([]models.AnimeCache, int, error) {
+func (m *MockAniListClient) GetAnimeDetailsByID(ctx context.Context, id int) (*models.AnimeDetails, error) {
+	args := m.Called(id)
+	var details *models.AnimeDetails
+	if args.Get(0) != nil {
+		details = args.Get(0).(*models.AnimeDetails)
+	}
+	return details, args.Error(1)
+}
+
+func (m *MockAniListClient) GetAnimeByIDs(ctx context.Context, ids []int) ([]models.AnimeDetails, error) {
+	args := m.Called(ids)
+	var animes []models.AnimeDetails
+	if args.Get(0) != nil {
+		animes = args.Get(0).([]models.AnimeDetails)
+	}
+	return animes, args.Error(1)
+}
+
+func (m *MockAniListClient) SearchAnime(ctx context.Context, query string, page int, perPage int) ([]models.AnimeCache, int, error) {
 	args := m.Called(query, page, perPage)
 	var animes []models.AnimeCache
 	if args.Get(0) != nil {
@@ -37,7 +60,7 @@ func (m *MockAniListClient) SearchAnime(query string, page int, perPage int) ([]
 	return animes, args.Int(1), args.Error(2)
 }
 
-func (m *MockAniListClient) GetPopularAnime(page int, perPage int) ([]models.AnimeCache, int, error) {
+func (m *MockAniListClient) GetPopularAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error) {
 	args := m.Called(page, perPage)
 	var animes []models.AnimeCache
 	if args.Get(0) != nil {
@@ -46,7 +69,7 @@ func (m *MockAniListClient) GetPopularAnime(page int, perPage int) ([]models.Ani
 	return animes, args.Int(1), args.Error(2)
 }
 
-func (m *MockAniListClient) GetTrendingAnime(page int, perPage int) ([]models.AnimeCache, int, error) {
+func (m *MockAniListClient) GetTrendingAnime(ctx context.Context, page int, perPage int) ([]models.AnimeCache, int, error) {
 	args := m.Called(page, perPage)
 	var animes []models.AnimeCache
 	if args.Get(0) != nil {
@@ -55,7 +78,7 @@ func (m *MockAniListClient) GetTrendingAnime(page int, perPage int) ([]models.An
 	return animes, args.Int(1), args.Error(2)
 }
 
-func (m *MockAniListClient) GetAnimeBySeason(year int, season string, page int, perPage int) ([]models.AnimeCache, int, error) {
+func (m *MockAniListClient) GetAnimeBySeason(ctx context.Context, year int, season string, page int, perPage int) ([]models.AnimeCache, int, error) {
 	args := m.Called(year, season, page, perPage)
 	var animes []models.AnimeCache
 	if args.Get(0) != nil {
@@ -64,8 +87,24 @@ func (m *MockAniListClient) GetAnimeBySeason(year int, season string, page int,
 	return animes, args.Int(1), args.Error(2)
 }
 
+func (m *MockAniListClient) BrowseAnime(ctx context.Context, filter api.BrowseFilter, page int, perPage int) (api.BrowseResult, error) {
+	args := m.Called(filter, page, perPage)
+	return args.Get(0).(api.BrowseResult), args.Error(1)
+}
+
+func (m *MockAniListClient) GetFranchise(ctx context.Context, id int) (*api.Franchise, error) {
+	args := m.Called(id)
+	var franchise *api.Franchise
+	if args.Get(0) != nil {
+		franchise = args.Get(0).(*api.Franchise)
+	}
+	return franchise, args.Error(1)
+}
+
 // Test GetPopularAnime Endpoint
 func TestGetPopularAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
 	// Setup Mock API Client
 	mockAPI := new(MockAniListClient)
 	SetAniListClient(mockAPI) // Inject mock
@@ -82,6 +121,8 @@ func TestGetPopularAnime(t *testing.T) {
 		{ID: 2, Title: "Popular Anime 2"},
 	}
 	mockAPI.On("GetPopularAnime", page, perPage).Return(mockResults, total, nil)
+	// Results are added to the local catalogue
+	expectStoreAnime(mock, 1, 2)
 
 	// Setup Route
 	router.GET("/anime/popular", GetPopularAnime)
@@ -119,6 +160,8 @@ func TestGetPopularAnime(t *testing.T) {
 
 // Test GetTrendingAnime Endpoint (Similar structure to GetPopularAnime)
 func TestGetTrendingAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
 	mockAPI := new(MockAniListClient)
 	SetAniListClient(mockAPI)
 	router := SetupGin()
@@ -126,6 +169,8 @@ func TestGetTrendingAnime(t *testing.T) {
 	page, perPage, total := 1, 3, 7
 	mockResults := []models.AnimeCache{{ID: 10, Title: "Trending 1"}}
 	mockAPI.On("GetTrendingAnime", page, perPage).Return(mockResults, total, nil)
+	// Results are added to the local catalogue
+	expectStoreAnime(mock, 10)
 
 	router.GET("/anime/trending", GetTrendingAnime)
 
@@ -140,6 +185,8 @@ func TestGetTrendingAnime(t *testing.T) {
 
 // Test GetAnimeBySeason Endpoint
 func TestGetAnimeBySeason(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
 	mockAPI := new(MockAniListClient)
 	SetAniListClient(mockAPI)
 	router := SetupGin()
@@ -148,6 +195,8 @@ func TestGetAnimeBySeason(t *testing.T) {
 	page, perPage, total := 1, 2, 5
 	mockResults := []models.AnimeCache{{ID: 20, Title: "Spring Anime"}}
 	mockAPI.On("GetAnimeBySeason", year, season, page, perPage).Return(mockResults, total, nil)
+	// Results are added to the local catalogue
+	expectStoreAnime(mock, 20)
 
 	router.GET("/anime/season/:year/:season", GetAnimeBySeason)
 
@@ -163,6 +212,8 @@ func TestGetAnimeBySeason(t *testing.T) {
 
 // Test GetAnimeRecommendations Endpoint (Uses GetPopularAnime mock for now)
 func TestGetAnimeRecommendations(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
 	mockAPI := new(MockAniListClient)
 	SetAniListClient(mockAPI)
 	router := SetupGin()
@@ -171,6 +222,8 @@ func TestGetAnimeRecommendations(t *testing.T) {
 	mockResults := []models.AnimeCache{{ID: 30, Title: "Recommended Anime"}}
 	// Mocking GetPopularAnime as it's the placeholder
 	mockAPI.On("GetPopularAnime", page, perPage).Return(mockResults, total, nil)
+	// Results are added to the local catalogue
+	expectStoreAnime(mock, 30)
 
 	router.GET("/anime/recommendations", func(c *gin.Context) {
 		// Simulate auth if needed by actual implementation
@@ -186,3 +239,52 @@ func TestGetAnimeRecommendations(t *testing.T) {
 	// ... add more assertions for body content ...
 	mockAPI.AssertExpectations(t)
 }
+
+// Test GetAnimeDetails returns 404 when AniList has no such anime
+func TestGetAnimeDetailsNotFound(t *testing.T) {
+	_, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mockAPI.On("GetAnimeDetailsByID", 999999).Return(nil, fmt.Errorf("failed to fetch anime: %w", api.ErrNotFound))
+
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/999999", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockAPI.AssertExpectations(t)
+}
+
+// Test an anime missing from the cache is stored before the provider that references it
+func TestAddWatchProviderCachesAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+	router.POST("/anime/provider", AddWatchProvider)
+
+	details := &models.AnimeDetails{ID: 30, Genres: []string{"Action"}}
+	mockAPI.On("GetAnimeByID", 30).Return(details, nil)
+
+	// The cache entry cannot be stored: the provider is not saved either
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE "anime_caches"."id" = $1`)).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "anime_caches"`)).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/anime/provider", strings.NewReader(`{"anime_id":30,"provider_name":"Crunchyroll"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
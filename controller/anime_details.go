@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
+)
+
+// Sections of the anime details that GetAnimeDetails only returns when asked for
+// with ?include=, e.g. ?include=characters,relations
+const (
+	DetailsTags              = "tags"
+	DetailsCharacters        = "characters"
+	DetailsStaff             = "staff"
+	DetailsRelations         = "relations"
+	DetailsTrailer           = "trailer"
+	DetailsExternalLinks     = "external_links"
+	DetailsNextAiringEpisode = "next_airing_episode"
+)
+
+var detailsSections = []string{
+	DetailsTags, DetailsCharacters, DetailsStaff, DetailsRelations,
+	DetailsTrailer, DetailsExternalLinks, DetailsNextAiringEpisode,
+}
+
+// parseDetailsInclude reads the comma separated sections of the include parameter;
+// "all" selects every section
+func parseDetailsInclude(value string) (map[string]bool, *apperr.Error) {
+	include := map[string]bool{}
+	for _, section := range splitList([]string{value}) {
+		section = strings.ToLower(section)
+		if section == "all" {
+			for _, s := range detailsSections {
+				include[s] = true
+			}
+			continue
+		}
+		if !slices.Contains(detailsSections, section) {
+			return nil, apperr.InvalidFields(validation.FieldError{
+				Field:   "include",
+				Code:    "oneof",
+				Message: "must be a list of: all " + strings.Join(detailsSections, " "),
+			})
+		}
+		include[section] = true
+	}
+	return include, nil
+}
+
+// withDetailsSections returns a copy of the details without the sections not included.
+// The cached details are shared, so they are not changed.
+func withDetailsSections(details *models.AnimeDetails, include map[string]bool) *models.AnimeDetails {
+	result := *details
+	if !include[DetailsTags] {
+		result.Tags = nil
+	}
+	if !include[DetailsCharacters] {
+		result.Characters = nil
+	}
+	if !include[DetailsStaff] {
+		result.Staff = nil
+	}
+	if !include[DetailsRelations] {
+		result.Relations = nil
+	}
+	if !include[DetailsTrailer] {
+		result.Trailer = nil
+	}
+	if !include[DetailsExternalLinks] {
+		result.ExternalLinks = nil
+	}
+	if !include[DetailsNextAiringEpisode] {
+		result.NextAiringEpisode = nil
+	}
+	return &result
+}
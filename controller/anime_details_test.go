@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// Test included sections are fetched when the cache only has the batch details, and the others are left out
+func TestGetAnimeDetailsInclude(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	details := &models.AnimeDetails{
+		ID:         24,
+		Status:     "RELEASING",
+		Tags:       []models.MediaTag{{ID: 1, Name: "Time Skip", Rank: 80, IsMediaSpoiler: true}},
+		Characters: &models.CharacterConnection{Edges: []models.CharacterEdge{{Role: "MAIN"}}},
+		Staff:      &models.StaffConnection{},
+		Relations:  &models.RelationConnection{Edges: []models.RelationEdge{{RelationType: "SEQUEL"}}},
+		Trailer:    &models.Trailer{ID: "abc", Site: "youtube"},
+	}
+	details.Title.English = "Extended Anime"
+	mockAPI.On("GetAnimeDetailsByID", 24).Return(details, nil)
+
+	fetched := time.Now().Add(-time.Minute)
+	expectAnimeCacheRow(mock, 24, sqlmock.NewRows(animeCacheColumns).
+		AddRow(24, "Extended Anime", "RELEASING", cachedDetails(t, 24, "RELEASING"), fetched))
+	expectStoreAnime(mock, 24)
+	expectProviders(mock, 24)
+
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/24?include=tags,Relations", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "wawatch; fwd=partial; stored", w.Header().Get(CacheStatusHeader))
+	assert.Contains(t, w.Body.String(), `"isMediaSpoiler":true`)
+	assert.Contains(t, w.Body.String(), `"relationType":"SEQUEL"`)
+	assert.NotContains(t, w.Body.String(), `"characters"`)
+	assert.NotContains(t, w.Body.String(), `"trailer"`)
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The fetched details keep every section for later requests
+	assert.NotNil(t, details.Characters)
+}
+
+// Test unknown sections are rejected
+func TestGetAnimeDetailsInvalidInclude(t *testing.T) {
+	router := SetupGin()
+	router.GET("/anime/:id", GetAnimeDetails)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/24?include=tags,reviews", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"include"`)
+}
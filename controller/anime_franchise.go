@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// franchiseNode is an anime of a franchise with its entry on the user's list, if any
+type franchiseNode struct {
+	api.FranchiseNode
+	ListStatus string `json:"list_status,omitempty"`
+	Progress   *int   `json:"progress,omitempty"`
+}
+
+// franchiseNodes annotates the nodes with the user's list entries; userID 0 is an anonymous caller
+func franchiseNodes(ctx context.Context, franchise *api.Franchise, userID uint) ([]franchiseNode, error) {
+	nodes := make([]franchiseNode, len(franchise.Nodes))
+	for i, node := range franchise.Nodes {
+		nodes[i] = franchiseNode{FranchiseNode: node}
+	}
+	if userID == 0 || len(nodes) == 0 {
+		return nodes, nil
+	}
+
+	var entries []models.UserAnimeList
+	if err := config.DB.WithContext(ctx).
+		Select("anime_external_id", "status", "progress").
+		Where("user_id = ? AND anime_external_id IN ?", userID, franchise.ReleaseOrder).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	byAnime := make(map[int]models.UserAnimeList, len(entries))
+	for _, entry := range entries {
+		byAnime[entry.AnimeExternalID] = entry
+	}
+	for i := range nodes {
+		if entry, ok := byAnime[nodes[i].ID]; ok {
+			progress := entry.Progress
+			nodes[i].ListStatus = entry.Status
+			nodes[i].Progress = &progress
+		}
+	}
+	return nodes, nil
+}
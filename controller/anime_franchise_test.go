@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+)
+
+// Test the franchise is annotated with the user's list status of each anime
+func TestGetAnimeFranchise(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mockAPI.On("GetFranchise", 2).Return(&api.Franchise{
+		RootID:             2,
+		Nodes:              []api.FranchiseNode{{ID: 1, Title: "Season 1"}, {ID: 2, Title: "Season 2", Depth: 0}},
+		Edges:              []api.FranchiseEdge{{From: 1, To: 2, RelationType: api.RelationSequel}},
+		ReleaseOrder:       []int{1, 2},
+		ChronologicalOrder: []int{1, 2},
+	}, nil)
+	mock.ExpectQuery(EscapeQuery(`SELECT "anime_external_id","status","progress" FROM "user_anime_lists" WHERE (user_id = $1 AND anime_external_id IN ($2,$3)) AND "user_anime_lists"."deleted_at" IS NULL`)).
+		WithArgs(5, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"anime_external_id", "status", "progress"}).AddRow(1, models.Completed, 12))
+
+	router.GET("/anime/:id/franchise", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 5}})
+	}, GetAnimeFranchise)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/2/franchise", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `"title":"Season 1","format":"","status":"","start_date":{"year":0,"month":0,"day":0},"cover_image":"","depth":0,"list_status":"COMPLETED","progress":12}`)
+	assert.Contains(t, body, `"title":"Season 2","format":"","status":"","start_date":{"year":0,"month":0,"day":0},"cover_image":"","depth":0}`)
+	assert.Contains(t, body, `"watch_order":{"chronological":[1,2],"release":[1,2]}`)
+	assert.Contains(t, body, `"edges":[{"from":1,"to":2,"relation_type":"SEQUEL"}]`)
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test an anime AniList does not know is a 404
+func TestGetAnimeFranchiseNotFound(t *testing.T) {
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mockAPI.On("GetFranchise", 404).Return(nil, api.ErrNotFound)
+	router.GET("/anime/:id/franchise", GetAnimeFranchise)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/404/franchise", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
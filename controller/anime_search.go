@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm/clause"
+)
+
+// Search sources accepted by SearchAnime's source parameter
+const (
+	SearchSourceLocal  = "local"  // Only the local catalogue
+	SearchSourceRemote = "remote" // Only AniList
+	SearchSourceAuto   = "auto"   // AniList, falling back to the local catalogue when it fails
+)
+
+// localSearchCondition matches whole words through the tsvector, and prefixes and
+// typos through trigram word similarity (pg_trgm's <% operator) on the lower-cased titles
+const localSearchCondition = "search_vector @@ websearch_to_tsquery('simple', @query) OR @query <% search_text"
+
+// localSearchRank orders the best matches first: full-text rank plus trigram similarity
+const localSearchRank = "ts_rank(search_vector, websearch_to_tsquery('simple', @query)) + word_similarity(@query, search_text) DESC"
+
+// searchLocalAnime searches all title variants and synonyms in the local catalogue
+func searchLocalAnime(ctx context.Context, query string, page int, perPage int) ([]models.AnimeCache, int, error) {
+	named := sql.Named("query", strings.ToLower(strings.TrimSpace(query)))
+
+	var total int64
+	if err := config.DB.WithContext(ctx).Model(&models.AnimeCache{}).
+		Where(localSearchCondition, named).
+		Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	animes := []models.AnimeCache{}
+	if total == 0 {
+		return animes, 0, nil
+	}
+	if err := config.DB.WithContext(ctx).
+		Where(localSearchCondition, named).
+		Clauses(clause.OrderBy{Expression: clause.NamedExpr{SQL: localSearchRank + ", id", Vars: []interface{}{named}}}).
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&animes).Error; err != nil {
+		return nil, 0, err
+	}
+	return animes, int(total), nil
+}
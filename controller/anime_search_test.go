@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+)
+
+// expectLocalSearch expects the local catalogue search for the query and returns the rows
+func expectLocalSearch(mock sqlmock.Sqlmock, query string, total int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(EscapeQuery(`SELECT count(*) FROM "anime_caches" WHERE (search_vector @@ websearch_to_tsquery('simple', $1) OR $2 <% search_text) AND "anime_caches"."deleted_at" IS NULL`)).
+		WithArgs(query, query).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE (search_vector @@ websearch_to_tsquery('simple', $1) OR $2 <% search_text) AND "anime_caches"."deleted_at" IS NULL ORDER BY ts_rank(search_vector, websearch_to_tsquery('simple', $3)) + word_similarity($4, search_text) DESC, id LIMIT $5`)).
+		WithArgs(query, query, query, query, 20).
+		WillReturnRows(rows)
+}
+
+// Test source=local searches only the local catalogue, with typos tolerated by the database
+func TestSearchAnimeLocal(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	expectLocalSearch(mock, "frieren beyond", 1, sqlmock.NewRows([]string{"id", "title", "title_romaji"}).
+		AddRow(154587, "Frieren: Beyond Journey's End", "Sousou no Frieren"))
+
+	router.GET("/anime/search", SearchAnime)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/search?q=Frieren+Beyond&source=local", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var responseBody struct {
+		Data []map[string]interface{} `json:"data"`
+		Meta map[string]interface{}   `json:"meta"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.Len(t, responseBody.Data, 1)
+	assert.Equal(t, "Sousou no Frieren", responseBody.Data[0]["title_romaji"])
+	assert.Equal(t, "local", responseBody.Meta["source"])
+	mockAPI.AssertNotCalled(t, "SearchAnime", "Frieren Beyond", 1, 20)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the default source falls back to the local catalogue while AniList is rate limiting us
+func TestSearchAnimeFallsBackToLocal(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mockAPI.On("SearchAnime", "naruto", 1, 20).
+		Return(nil, 0, fmt.Errorf("failed: %w", &api.StatusError{StatusCode: http.StatusTooManyRequests}))
+	expectLocalSearch(mock, "naruto", 1, sqlmock.NewRows([]string{"id", "title"}).AddRow(20, "Naruto"))
+
+	router.GET("/anime/search", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 1}})
+	}, SearchAnime)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/search?q=naruto", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"source":"local"`)
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// With source=remote the failure is reported instead
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/anime/search?q=naruto&source=remote", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// Test anonymous users only search the local catalogue, as AniList results are written to it
+func TestSearchAnimeAnonymous(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+	router.GET("/anime/search", SearchAnime)
+
+	for _, source := range []string{"remote", "auto"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/anime/search?q=naruto&source="+source, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, source)
+	}
+	mockAPI.AssertNotCalled(t, "SearchAnime", "naruto", 1, 20)
+
+	// Without a source they get the local catalogue
+	expectLocalSearch(mock, "naruto", 1, sqlmock.NewRows([]string{"id", "title"}).AddRow(20, "Naruto"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/anime/search?q=naruto", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"source":"local"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
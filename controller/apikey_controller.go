@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
+)
+
+// maxAPIKeysPerUser caps the active keys of one user
+const maxAPIKeysPerUser = 25
+
+// GetMyAPIKeys lists the logged-in user's API keys that have not been revoked
+func GetMyAPIKeys(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	keys := []models.APIKey{}
+	if err := config.DB.Where("user_id = ? AND revoked_at IS NULL", user.ID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		c.Error(apperr.Internal("Failed to retrieve API keys", err))
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey issues a new API key. The key is only part of this response.
+// Without scopes the key gets all of them.
+func CreateAPIKey(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var body struct {
+		Name          string   `json:"name" binding:"required,max=100"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=3650"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	scopes := pq.StringArray{}
+	for _, scope := range body.Scopes {
+		if !auth.IsValidScope(scope) {
+			c.Error(apperr.InvalidFields(validation.FieldError{Field: "scopes", Code: "invalid", Message: "contains an unknown scope: " + scope}))
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		for _, scope := range auth.AllScopes {
+			scopes = append(scopes, string(scope))
+		}
+	}
+
+	var active int64
+	if err := config.DB.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active).Error; err != nil {
+		c.Error(apperr.Internal("Failed to create API key", err))
+		return
+	}
+	if active >= maxAPIKeysPerUser {
+		c.Error(apperr.Conflict("Too many API keys, revoke one first"))
+		return
+	}
+
+	key, visible, err := auth.NewAPIKey()
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate API key", err))
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:  user.ID,
+		Name:    body.Name,
+		Prefix:  visible,
+		KeyHash: auth.HashToken(key),
+		Scopes:  scopes,
+	}
+	if body.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := config.DB.Create(&apiKey).Error; err != nil {
+		c.Error(apperr.Internal("Failed to create API key", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         apiKey.ID,
+		"name":       apiKey.Name,
+		"prefix":     apiKey.Prefix,
+		"scopes":     apiKey.Scopes,
+		"expires_at": apiKey.ExpiresAt,
+		"key":        key,
+	})
+}
+
+// RevokeAPIKey revokes one of the logged-in user's API keys
+func RevokeAPIKey(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.BadRequest("Invalid API key ID"))
+		return
+	}
+
+	result := config.DB.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, user.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.Error(apperr.Internal("Failed to revoke API key", result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Error(apperr.NotFound("API key not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
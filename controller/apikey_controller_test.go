@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/middleware"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+)
+
+var apiKeyColumns = []string{"id", "created_at", "updated_at", "deleted_at", "user_id", "name", "prefix", "key_hash",
+	"scopes", "expires_at", "last_used_at", "revoked_at"}
+
+// Test CreateAPIKey returns the key once and stores only its hash
+func TestCreateAPIKey(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT count(*) FROM "api_keys"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "api_keys"`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 1, "discord bot", sqlmock.AnyArg(), sqlmock.AnyArg(), `{"list:read"}`, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	router.POST("/profile/api-keys", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 1}, Username: "scripter"})
+	}, CreateAPIKey)
+
+	requestBody, _ := json.Marshal(gin.H{"name": "discord bot", "scopes": []string{"list:read"}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/profile/api-keys", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+		return
+	}
+	var responseBody struct {
+		Key    string `json:"key"`
+		Prefix string `json:"prefix"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.True(t, strings.HasPrefix(responseBody.Key, responseBody.Prefix))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test API keys only pass routes that accept them with a scope they were granted
+func TestAPIKeyScopes(t *testing.T) {
+	key := "wwk_test-key"
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		guard    gin.HandlerFunc
+		wantCode int
+	}{
+		{name: "granted scope", guard: middleware.RequireAuthWithScope(auth.ScopeListRead), wantCode: http.StatusOK},
+		{name: "missing scope", guard: middleware.RequireAuthWithScope(auth.ScopeListWrite), wantCode: http.StatusForbidden},
+		{name: "session only route", guard: middleware.RequireAuth, wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, cleanup := SetupTestDB(t)
+			defer cleanup()
+			router := SetupGin()
+
+			mock.ExpectQuery(EscapeQuery(`SELECT * FROM "api_keys" WHERE key_hash = $1`)).
+				WithArgs(auth.HashToken(key), 1).
+				WillReturnRows(sqlmock.NewRows(apiKeyColumns).
+					AddRow(3, now, now, nil, 1, "bot", "wwk_test-key", auth.HashToken(key), "{list:read}", nil, now, nil))
+			if tt.wantCode == http.StatusOK {
+				mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE id = $1`)).
+					WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, now, now, nil, "scripter", "hash", nil, nil, "user", "default.jpg"))
+			}
+
+			router.GET("/", tt.guard, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(middleware.APIKeyHeader, key)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/middleware"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// refreshCookiePath limits the refresh cookie to the auth endpoints
+const refreshCookiePath = "/auth"
+
+// issueSession creates a new session for the user and returns the token pair in the login response shape
+func issueSession(c *gin.Context, user models.User, deviceName string) (gin.H, error) {
+	refreshToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		DeviceName:       deviceName,
+		IPAddress:        c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(config.AppSettings.Auth.RefreshTokenTTL),
+	}
+	if err := config.DB.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return tokenResponse(c, user.ID, session, refreshToken)
+}
+
+// tokenResponse signs an access token for the session, sets both cookies and builds the response body
+func tokenResponse(c *gin.Context, userID uint, session models.Session, refreshToken string) (gin.H, error) {
+	accessToken, _, err := auth.IssueAccessToken(userID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	authSettings := config.AppSettings.Auth
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(middleware.AuthCookieName, accessToken, authSettings.CookieMaxAgeSeconds(), "/", authSettings.CookieDomain, authSettings.CookieSecure, true)
+	c.SetCookie(middleware.RefreshCookieName, refreshToken, int(time.Until(session.ExpiresAt).Seconds()), refreshCookiePath, authSettings.CookieDomain, authSettings.CookieSecure, true)
+
+	return gin.H{
+		"token":              accessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(authSettings.TokenTTL.Seconds()),
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": session.ExpiresAt,
+		"session_id":         session.ID,
+	}, nil
+}
+
+// clearAuthCookies expires both auth cookies in the browser
+func clearAuthCookies(c *gin.Context) {
+	authSettings := config.AppSettings.Auth
+	c.SetCookie(middleware.AuthCookieName, "", -1, "/", authSettings.CookieDomain, authSettings.CookieSecure, true)
+	c.SetCookie(middleware.RefreshCookieName, "", -1, refreshCookiePath, authSettings.CookieDomain, authSettings.CookieSecure, true)
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh token pair.
+// Every refresh rotates the refresh token; presenting an already rotated token
+// means it was copied, so the whole session is revoked.
+func RefreshToken(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// The body is optional, browsers send the cookie instead
+	_ = c.ShouldBindJSON(&body)
+
+	refreshToken := body.RefreshToken
+	if refreshToken == "" {
+		refreshToken, _ = c.Cookie(middleware.RefreshCookieName)
+	}
+	if refreshToken == "" {
+		c.Error(apperr.BadRequest("Refresh token is required"))
+		return
+	}
+
+	tokenHash := auth.HashToken(refreshToken)
+	now := time.Now()
+
+	var session models.Session
+	if err := config.DB.Where("refresh_token_hash = ?", tokenHash).First(&session).Error; err != nil {
+		// Not the current token - check whether it is one we already rotated away
+		var reused models.Session
+		if config.DB.Where("previous_token_hash = ?", tokenHash).First(&reused).Error == nil {
+			revokeSession(&reused, now)
+			log.Printf("Refresh token reuse detected for session %d (user %d), session revoked", reused.ID, reused.UserID)
+		}
+		clearAuthCookies(c)
+		c.Error(apperr.Unauthorized("Invalid refresh token"))
+		return
+	}
+
+	if !session.IsActive(now) {
+		clearAuthCookies(c)
+		c.Error(apperr.Unauthorized("Session expired or revoked"))
+		return
+	}
+
+	newRefreshToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate token", err))
+		return
+	}
+
+	// Conditional update: if a concurrent request rotated the token first, this one lost the race
+	result := config.DB.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, tokenHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  auth.HashToken(newRefreshToken),
+			"previous_token_hash": tokenHash,
+			"last_used_at":        now,
+			"expires_at":          now.Add(config.AppSettings.Auth.RefreshTokenTTL),
+			"ip_address":          c.ClientIP(),
+			"user_agent":          c.Request.UserAgent(),
+		})
+	if result.Error != nil {
+		c.Error(apperr.Internal("Failed to refresh session", result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		revokeSession(&session, now)
+		log.Printf("Concurrent refresh detected for session %d (user %d), session revoked", session.ID, session.UserID)
+		clearAuthCookies(c)
+		c.Error(apperr.Unauthorized("Invalid refresh token"))
+		return
+	}
+	session.ExpiresAt = now.Add(config.AppSettings.Auth.RefreshTokenTTL)
+
+	response, err := tokenResponse(c, session.UserID, session, newRefreshToken)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate token", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the session the request was authenticated with
+func Logout(c *gin.Context) {
+	sessionInterface, exists := c.Get("session")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	session := sessionInterface.(models.Session)
+
+	if err := revokeSession(&session, time.Now()); err != nil {
+		c.Error(apperr.Internal("Failed to log out", err))
+		return
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// GetMySessions lists the active sessions of the logged-in user
+func GetMySessions(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var currentSessionID uint
+	if sessionInterface, ok := c.Get("session"); ok {
+		currentSessionID = sessionInterface.(models.Session).ID
+	}
+
+	var sessions []models.Session
+	if err := config.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.Error(apperr.Internal("Failed to retrieve sessions", err))
+		return
+	}
+
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":           session.ID,
+			"device_name":  session.DeviceName,
+			"ip_address":   session.IPAddress,
+			"user_agent":   session.UserAgent,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == currentSessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RevokeMySession revokes one of the logged-in user's sessions (e.g. a lost phone)
+func RevokeMySession(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperr.BadRequest("Invalid session ID"))
+		return
+	}
+
+	var session models.Session
+	if err := config.DB.Where("id = ? AND user_id = ?", sessionID, user.ID).First(&session).Error; err != nil {
+		c.Error(apperr.NotFound("Session not found"))
+		return
+	}
+
+	if session.RevokedAt == nil {
+		if err := revokeSession(&session, time.Now()); err != nil {
+			c.Error(apperr.Internal("Failed to revoke session", err))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// revokeSession marks the session as revoked; access tokens bound to it stop working immediately
+func revokeSession(session *models.Session, now time.Time) error {
+	session.RevokedAt = &now
+	return config.DB.Model(&models.Session{}).Where("id = ?", session.ID).Update("revoked_at", now).Error
+}
+
+// revokeAllSessions revokes every active session of the user
+func revokeAllSessions(userID uint) error {
+	return config.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/auth"
+)
+
+var sessionColumns = []string{"id", "created_at", "updated_at", "deleted_at", "user_id", "refresh_token_hash", "previous_token_hash",
+	"device_name", "ip_address", "user_agent", "last_used_at", "expires_at", "revoked_at"}
+
+// Test RefreshToken rotates the refresh token of an active session
+func TestRefreshTokenRotates(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	oldToken := "old-refresh-token"
+	oldHash := auth.HashToken(oldToken)
+	now := time.Now()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "sessions" WHERE refresh_token_hash = $1 AND "sessions"."deleted_at" IS NULL ORDER BY "sessions"."id" LIMIT $2`)).
+		WithArgs(oldHash, 1).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).
+			AddRow(7, now, now, nil, 3, oldHash, "", "laptop", "127.0.0.1", "test", now, now.Add(time.Hour), nil))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "sessions" SET "expires_at"=$1,"ip_address"=$2,"last_used_at"=$3,"previous_token_hash"=$4,"refresh_token_hash"=$5,"user_agent"=$6,"updated_at"=$7 WHERE (id = $8 AND refresh_token_hash = $9) AND "sessions"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), oldHash, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 7, oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	router.POST("/auth/refresh", RefreshToken)
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": oldToken})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		return
+	}
+
+	var responseBody map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.NotEmpty(t, responseBody["token"])
+	assert.NotEqual(t, oldToken, responseBody["refresh_token"])
+
+	claims, err := auth.ParseAccessToken(responseBody["token"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), claims.SessionID)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test RefreshToken revokes the session when an already rotated token is presented again
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	reusedToken := "rotated-refresh-token"
+	reusedHash := auth.HashToken(reusedToken)
+	now := time.Now()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "sessions" WHERE refresh_token_hash = $1 AND "sessions"."deleted_at" IS NULL ORDER BY "sessions"."id" LIMIT $2`)).
+		WithArgs(reusedHash, 1).
+		WillReturnRows(sqlmock.NewRows(sessionColumns))
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "sessions" WHERE previous_token_hash = $1 AND "sessions"."deleted_at" IS NULL ORDER BY "sessions"."id" LIMIT $2`)).
+		WithArgs(reusedHash, 1).
+		WillReturnRows(sqlmock.NewRows(sessionColumns).
+			AddRow(9, now, now, nil, 3, "current-hash", reusedHash, "phone", "127.0.0.1", "test", now, now.Add(time.Hour), nil))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "sessions" SET "revoked_at"=$1,"updated_at"=$2 WHERE id = $3 AND "sessions"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	router.POST("/auth/refresh", RefreshToken)
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": reusedToken})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// background tracks the work handlers start after responding, e.g. cache refreshes and mail,
+// so shutdown can wait for it before the database is closed. Once stopped, nothing is started.
+var background = struct {
+	sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}{}
+
+// backgroundCtx is the parent of background tasks, cancelled when shutdown runs out of time
+var backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+
+// goBackground runs task in its own goroutine with a context bounded by timeout.
+// It reports false, without running task, once shutdown has started.
+func goBackground(timeout time.Duration, task func(ctx context.Context)) bool {
+	background.Lock()
+	if background.stopped {
+		background.Unlock()
+		return false
+	}
+	background.wg.Add(1)
+	background.Unlock()
+
+	go func() {
+		defer background.wg.Done()
+		ctx, cancel := context.WithTimeout(backgroundCtx, timeout)
+		defer cancel()
+		task(ctx)
+	}()
+	return true
+}
+
+// StopBackgroundTasks stops starting background tasks and waits for the running ones.
+// When ctx expires first they are cancelled. It is a shutdown hook, run before the database is closed.
+func StopBackgroundTasks(ctx context.Context) error {
+	background.Lock()
+	background.stopped = true
+	background.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		background.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancelBackground()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// resetBackground lets background tasks run again after a test stopped them
+func resetBackground() {
+	background.Lock()
+	background.stopped = false
+	background.Unlock()
+	backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+}
+
+// waitBackground waits until the background tasks started so far are done
+func waitBackground() {
+	background.wg.Wait()
+}
+
+// Test shutdown waits for background tasks, cancels them when out of time and keeps new ones from starting
+func TestStopBackgroundTasks(t *testing.T) {
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	t.Cleanup(resetBackground)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	mockAPI.On("GetAnimeDetailsByID", 24).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(nil, errors.New("anilist API returned status 502"))
+
+	refreshInBackground(24)
+	<-started
+
+	// The refresh is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, StopBackgroundTasks(ctx), context.DeadlineExceeded)
+	assert.Error(t, backgroundCtx.Err())
+
+	close(release)
+	assert.NoError(t, StopBackgroundTasks(context.Background()))
+
+	refreshInBackground(25)
+	mockAPI.AssertNumberOfCalls(t, "GetAnimeDetailsByID", 1)
+}
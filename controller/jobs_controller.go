@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/worker"
+)
+
+// Job names, also used in the /admin/jobs URLs
+const (
+	JobAccountPurge = "account-purge"
+	JobAnimeRefresh = "anime-refresh"
+)
+
+// scheduler runs the background jobs; nil until main sets it
+var scheduler *worker.Scheduler
+
+// SetScheduler makes the background jobs visible to the admin endpoints
+func SetScheduler(s *worker.Scheduler) {
+	scheduler = s
+}
+
+// GetJobs lists the background jobs and their last runs (admin only)
+func GetJobs(c *gin.Context) {
+	if scheduler == nil {
+		c.JSON(http.StatusOK, gin.H{"jobs": []worker.JobStatus{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": scheduler.Statuses()})
+}
+
+// RunJob starts a background job now instead of waiting for its next run (admin only)
+func RunJob(c *gin.Context) {
+	if scheduler == nil {
+		c.Error(apperr.NotFound("Job not found"))
+		return
+	}
+
+	name := c.Param("name")
+	err := scheduler.Trigger(name)
+	switch {
+	case errors.Is(err, worker.ErrUnknownJob):
+		c.Error(apperr.NotFound("Job not found"))
+		return
+	case errors.Is(err, worker.ErrJobRunning):
+		c.Error(apperr.Conflict("Job is already running"))
+		return
+	case err != nil:
+		c.Error(apperr.Internal("Failed to start job", err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job started", "job": name})
+}
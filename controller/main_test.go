@@ -8,11 +8,17 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/middleware"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+func init() {
+	// Tokens are signed during tests, so a secret has to be configured
+	config.AppSettings.Auth.JWTSecret = "test-secret"
+}
+
 // MockAnyTime is used to match any time value in sqlmock
 type MockAnyTime struct{}
 
@@ -59,6 +65,7 @@ func SetupTestDB(t *testing.T) (sqlmock.Sqlmock, func()) {
 func SetupGin() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
+	router.Use(middleware.ErrorHandler())
 	return router
 }
 
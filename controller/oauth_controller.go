@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
+	"gorm.io/gorm"
+)
+
+// oauthStateCookie binds the OAuth state of a login to the browser that started it
+const oauthStateCookie = "OAuthState"
+
+// oauthStateTTL is how long the user has to approve access at AniList
+const oauthStateTTL = 10 * time.Minute
+
+// Use the interface type so tests can point the flow at a stub
+var anilistOAuth api.AniListOAuthAPI
+
+// SetAniListOAuth allows injecting the OAuth client (real or mock)
+func SetAniListOAuth(client api.AniListOAuthAPI) {
+	anilistOAuth = client
+}
+
+func init() {
+	SetAniListOAuth(api.NewAniListOAuth(config.AppSettings.AniList))
+}
+
+var errInvalidOAuthState = apperr.BadRequest("Invalid or expired OAuth state")
+
+// oauthState is what the signed state parameter carries through the AniList redirect
+type oauthState struct {
+	LinkUserID uint // Zero for a login, otherwise the user the identity is linked to
+	Nonce      string
+}
+
+// oauthAuthorizeURL signs the state carrying the nonce and returns the AniList URL the user has to visit
+func oauthAuthorizeURL(linkUserID uint, nonce string) (string, error) {
+	state, err := auth.IssuePurposeToken(auth.PurposeOAuthState, fmt.Sprintf("%d:%s", linkUserID, nonce), oauthStateTTL)
+	if err != nil {
+		return "", err
+	}
+	return anilistOAuth.AuthCodeURL(state), nil
+}
+
+// parseOAuthState verifies the signature of the state and reads what it carries
+func parseOAuthState(state string) (oauthState, error) {
+	subject, err := auth.ParsePurposeToken(state, auth.PurposeOAuthState)
+	if err != nil {
+		return oauthState{}, err
+	}
+	userPart, nonce, found := strings.Cut(subject, ":")
+	if !found {
+		return oauthState{}, errors.New("malformed state")
+	}
+	userID, err := strconv.ParseUint(userPart, 10, 64)
+	if err != nil {
+		return oauthState{}, errors.New("malformed state")
+	}
+	return oauthState{LinkUserID: uint(userID), Nonce: nonce}, nil
+}
+
+// oauthEnabled answers 404 when AniList login is not configured and reports whether it is
+func oauthEnabled(c *gin.Context) bool {
+	if !config.AppSettings.AniList.OAuthEnabled() {
+		c.Error(apperr.NotFound("AniList login is not available"))
+		return false
+	}
+	return true
+}
+
+// AniListLogin redirects to AniList to log in (or sign up) with an AniList account
+func AniListLogin(c *gin.Context) {
+	if !oauthEnabled(c) {
+		return
+	}
+
+	nonce, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.Error(apperr.Internal("Failed to start AniList login", err))
+		return
+	}
+	authorizeURL, err := oauthAuthorizeURL(0, nonce)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to start AniList login", err))
+		return
+	}
+
+	authSettings := config.AppSettings.Auth
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, nonce, int(oauthStateTTL.Seconds()), "/auth/anilist", authSettings.CookieDomain, authSettings.CookieSecure, true)
+	c.Redirect(http.StatusFound, authorizeURL)
+}
+
+// LinkAniList returns the AniList URL that links an AniList account to the logged-in user.
+// It answers with the URL instead of redirecting because it is called with a bearer token.
+// The browser may be any, so nothing ties the callback to the user: the callback hands the
+// code back and the user confirms it with ConfirmAniListLink, authenticated as themselves.
+func LinkAniList(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	if !oauthEnabled(c) {
+		return
+	}
+
+	nonce, err := createUserToken(user, models.TokenAniListLink, oauthStateTTL)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to start AniList linking", err))
+		return
+	}
+	authorizeURL, err := oauthAuthorizeURL(user.ID, nonce)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to start AniList linking", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorize_url": authorizeURL})
+}
+
+// AniListCallback finishes the flow. A login exchanges the code, looks up the AniList account
+// and logs in its owner, creating a new user on the first login. A link is not completed here:
+// the code and state are returned for the linking user to post to ConfirmAniListLink.
+func AniListCallback(c *gin.Context) {
+	if !oauthEnabled(c) {
+		return
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		c.Error(apperr.BadRequest("AniList authorization was denied"))
+		return
+	}
+
+	state, err := parseOAuthState(c.Query("state"))
+	if err != nil {
+		c.Error(errInvalidOAuthState)
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.Error(apperr.BadRequest("Authorization code is required"))
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Confirm linking the AniList account while logged in",
+			"code":    code,
+			"state":   c.Query("state"),
+		})
+		return
+	}
+
+	// A login must come back to the browser that started it
+	cookie, err := c.Cookie(oauthStateCookie)
+	if err != nil || cookie != state.Nonce {
+		c.Error(errInvalidOAuthState)
+		return
+	}
+	authSettings := config.AppSettings.Auth
+	c.SetCookie(oauthStateCookie, "", -1, "/auth/anilist", authSettings.CookieDomain, authSettings.CookieSecure, true)
+
+	identity, appErr := anilistIdentity(c, code)
+	if appErr != nil {
+		c.Error(appErr)
+		return
+	}
+	loginWithIdentity(c, identity)
+}
+
+// ConfirmAniListLink links the AniList account of a code from AniListCallback to the logged-in user.
+// The state must have been issued to this user by LinkAniList and is used once.
+func ConfirmAniListLink(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	if !oauthEnabled(c) {
+		return
+	}
+
+	var body struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	state, err := parseOAuthState(body.State)
+	if err != nil || state.LinkUserID != user.ID {
+		c.Error(errInvalidOAuthState)
+		return
+	}
+	token, err := consumeUserToken(state.Nonce, models.TokenAniListLink)
+	if err != nil || token.UserID != user.ID {
+		c.Error(errInvalidOAuthState)
+		return
+	}
+
+	identity, appErr := anilistIdentity(c, body.Code)
+	if appErr != nil {
+		c.Error(appErr)
+		return
+	}
+	linkIdentity(c, user.ID, identity)
+}
+
+// anilistIdentity exchanges the authorization code and looks up the AniList account it grants access to
+func anilistIdentity(c *gin.Context, code string) (models.UserIdentity, *apperr.Error) {
+	token, err := anilistOAuth.Exchange(c.Request.Context(), code)
+	if err != nil {
+		return models.UserIdentity{}, apperr.Upstream("Failed to authorize with AniList", err)
+	}
+	viewer, err := anilistOAuth.Viewer(c.Request.Context(), token.AccessToken)
+	if err != nil {
+		return models.UserIdentity{}, apperr.Upstream("Failed to fetch AniList account", err)
+	}
+
+	identity := models.UserIdentity{
+		Provider:         models.ProviderAniList,
+		ProviderUserID:   strconv.Itoa(viewer.ID),
+		ProviderUsername: viewer.Name,
+		AccessToken:      token.AccessToken,
+		RefreshToken:     token.RefreshToken,
+	}
+	if token.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+		identity.TokenExpiresAt = &expiresAt
+	}
+	return identity, nil
+}
+
+// linkIdentity attaches the external account to the user, replacing their previous one of the provider
+func linkIdentity(c *gin.Context, userID uint, identity models.UserIdentity) {
+	var existing models.UserIdentity
+	err := config.DB.Where("provider = ? AND provider_user_id = ?", identity.Provider, identity.ProviderUserID).First(&existing).Error
+	switch {
+	case err == nil && existing.UserID != userID:
+		c.Error(apperr.Conflict("This AniList account is linked to another user"))
+		return
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		c.Error(apperr.Internal("Failed to link AniList account", err))
+		return
+	}
+
+	identity.UserID = userID
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ? AND provider = ?", userID, identity.Provider).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		c.Error(apperr.Internal("Failed to link AniList account", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "AniList account linked", "identity": identity})
+}
+
+// loginWithIdentity logs in the owner of the external account, signing up a new user the first time
+func loginWithIdentity(c *gin.Context, identity models.UserIdentity) {
+	var existing models.UserIdentity
+	err := config.DB.Where("provider = ? AND provider_user_id = ?", identity.Provider, identity.ProviderUserID).First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Error(apperr.Internal("Failed to log in with AniList", err))
+		return
+	}
+
+	var user models.User
+	if err == nil {
+		if err := config.DB.First(&user, existing.UserID).Error; err != nil {
+			c.Error(apperr.Internal("Failed to log in with AniList", err))
+			return
+		}
+		// Keep the newest token for list sync
+		if err := config.DB.Model(&existing).Updates(map[string]interface{}{
+			"provider_username": identity.ProviderUsername,
+			"access_token":      identity.AccessToken,
+			"refresh_token":     identity.RefreshToken,
+			"token_expires_at":  identity.TokenExpiresAt,
+		}).Error; err != nil {
+			log.Printf("Failed to store AniList token for user %d: %v", user.ID, err)
+		}
+	} else {
+		user, err = signupWithIdentity(identity)
+		if err != nil {
+			c.Error(apperr.Internal("Failed to create user", err))
+			return
+		}
+	}
+
+	// The external login replaces the password, not the second factor
+	if user.TwoFactorEnabled() {
+		respondMFAChallenge(c, user)
+		return
+	}
+	completeLogin(c, user, "AniList login")
+}
+
+// signupWithIdentity creates a user without a password for a new external account.
+// Such users log in through the provider until they set a password via the reset flow.
+func signupWithIdentity(identity models.UserIdentity) (models.User, error) {
+	var user models.User
+	username, err := availableUsername(identity.ProviderUsername)
+	if err != nil {
+		return user, err
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		user = models.User{Username: username, Role: models.RoleUser}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(&identity).Error
+	})
+	return user, err
+}
+
+// availableUsername derives an unused, valid username from an external account name
+func availableUsername(name string) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		if r < 128 && (r == '_' || r == '.' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return -1
+	}, name)
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if !validation.IsUsername(base) {
+		base = "anilist_" + base
+		if !validation.IsUsername(base) {
+			base = "anilist_user"
+		}
+	}
+
+	for i := 1; i <= 20; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s_%d", base, i)
+		}
+		var count int64
+		if err := config.DB.Unscoped().Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no free username found")
+}
+
+// GetMyIdentities lists the external accounts linked to the logged-in user
+func GetMyIdentities(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	identities := []models.UserIdentity{}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("provider").Find(&identities).Error; err != nil {
+		c.Error(apperr.Internal("Failed to retrieve linked accounts", err))
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkIdentity removes a linked external account. It is refused when it is the
+// only way to log in, i.e. the user never set a password.
+func UnlinkIdentity(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	if user.Password == "" {
+		c.Error(apperr.BadRequest("Set a password before unlinking your last login method"))
+		return
+	}
+
+	result := config.DB.Unscoped().Where("user_id = ? AND provider = ?", user.ID, c.Param("provider")).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		c.Error(apperr.Internal("Failed to unlink account", result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.Error(apperr.NotFound("Linked account not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlinked"})
+}
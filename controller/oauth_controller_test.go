@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/api"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+)
+
+var identityColumns = []string{"id", "created_at", "updated_at", "deleted_at", "user_id", "provider", "provider_user_id",
+	"provider_username", "access_token", "refresh_token", "token_expires_at"}
+
+// stubAniList stands in for AniList's token and GraphQL endpoints and points the OAuth client at it
+func stubAniList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Write([]byte(`{"access_token":"anilist-access","token_type":"Bearer","expires_in":3600}`))
+		case "/graphql":
+			if r.Header.Get("Authorization") != "Bearer anilist-access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"data":{"Viewer":{"id":4242,"name":"Tanuki"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	previous := config.AppSettings.AniList
+	config.AppSettings.AniList = config.AniListSettings{
+		GraphQLURL:   server.URL + "/graphql",
+		AuthorizeURL: server.URL + "/oauth/authorize",
+		TokenURL:     server.URL + "/oauth/token",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/anilist/callback",
+	}
+	SetAniListOAuth(api.NewAniListOAuth(config.AppSettings.AniList))
+
+	t.Cleanup(func() {
+		server.Close()
+		config.AppSettings.AniList = previous
+		SetAniListOAuth(api.NewAniListOAuth(previous))
+	})
+}
+
+// Test the AniList callback logs in the user the AniList account is linked to
+func TestAniListCallbackLogsInLinkedUser(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	stubAniList(t)
+	router := SetupGin()
+	router.GET("/auth/anilist/login", AniListLogin)
+	router.GET("/auth/anilist/callback", AniListCallback)
+
+	// Start the flow to get the state and the cookie it is bound to
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/anilist/login", nil)
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusFound, w.Code) {
+		return
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+	cookies := w.Result().Cookies()
+
+	now := time.Now()
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_identities" WHERE (provider = $1 AND provider_user_id = $2)`)).
+		WithArgs("anilist", "4242", 1).
+		WillReturnRows(sqlmock.NewRows(identityColumns).
+			AddRow(1, now, now, nil, 9, "anilist", "4242", "Tanuki", "old", "", nil))
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(9, now, now, nil, "tanuki", "", nil, nil, "user", "default.jpg"))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "user_identities" SET "access_token"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "sessions"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectCommit()
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/auth/anilist/callback?code=abc&state="+url.QueryEscape(state), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+		return
+	}
+	var responseBody map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	claims, err := auth.ParseAccessToken(responseBody["token"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, "9", claims.Subject)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the callback refuses a state without the cookie of the browser that started the flow
+func TestAniListCallbackRejectsForeignState(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	stubAniList(t)
+	router := SetupGin()
+	router.GET("/auth/anilist/callback", AniListCallback)
+
+	state, _ := auth.IssuePurposeToken(auth.PurposeOAuthState, "0:nonce", time.Minute)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/anilist/callback?code=abc&state="+url.QueryEscape(state), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the callback of a link hands the code back instead of linking, even without cookies
+func TestAniListCallbackHandsBackLinkCode(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	stubAniList(t)
+	router := SetupGin()
+	router.GET("/auth/anilist/callback", AniListCallback)
+
+	state, _ := auth.IssuePurposeToken(auth.PurposeOAuthState, "9:link-nonce", time.Minute)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/anilist/callback?code=abc&state="+url.QueryEscape(state), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var responseBody map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.Equal(t, "abc", responseBody["code"])
+	assert.Equal(t, state, responseBody["state"])
+	// Nothing was consumed or linked
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// confirmLink posts the code and state to ConfirmAniListLink as the user, or anonymously for a zero ID
+func confirmLink(router *gin.Engine, userID uint, state string) *httptest.ResponseRecorder {
+	router.POST("/profile/identities/anilist/confirm", func(c *gin.Context) {
+		if userID != 0 {
+			c.Set("user", models.User{Model: gorm.Model{ID: userID}})
+		}
+		ConfirmAniListLink(c)
+	})
+	body, _ := json.Marshal(gin.H{"code": "abc", "state": state})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/profile/identities/anilist/confirm", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// Test the user who started the link confirms it, using up the state
+func TestConfirmAniListLink(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	stubAniList(t)
+
+	state, _ := auth.IssuePurposeToken(auth.PurposeOAuthState, "9:link-nonce", time.Minute)
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_tokens" WHERE (token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3)`)).
+		WithArgs(auth.HashToken("link-nonce"), "anilist_link", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "purpose"}).AddRow(3, 9, "anilist_link"))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "user_tokens" SET "used_at"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_identities" WHERE (provider = $1 AND provider_user_id = $2)`)).
+		WithArgs("anilist", "4242", 1).
+		WillReturnRows(sqlmock.NewRows(identityColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "user_identities" WHERE user_id = $1 AND provider = $2`)).
+		WithArgs(9, "anilist").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "user_identities"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	w := confirmLink(SetupGin(), 9, state)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "AniList account linked")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a link state is refused when replayed by another user, without a session, or once used
+func TestConfirmAniListLinkRejectsReplayedState(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	stubAniList(t)
+
+	state, _ := auth.IssuePurposeToken(auth.PurposeOAuthState, "9:link-nonce", time.Minute)
+
+	// The victim of a linking CSRF is logged in as someone else
+	assert.Equal(t, http.StatusBadRequest, confirmLink(SetupGin(), 10, state).Code)
+	assert.Equal(t, http.StatusUnauthorized, confirmLink(SetupGin(), 0, state).Code)
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_tokens" WHERE (token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3)`)).
+		WithArgs(auth.HashToken("link-nonce"), "anilist_link", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	assert.Equal(t, http.StatusBadRequest, confirmLink(SetupGin(), 9, state).Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/mailer"
+	"github.com/vrstep/wawatch-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activityEvent is one entry of the account timeline, rebuilt from the timestamps we keep
+type activityEvent struct {
+	At     time.Time `json:"at"`
+	Type   string    `json:"type"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// dataExport is everything stored about a user; each field becomes one file of the ZIP bundle
+type dataExport struct {
+	ExportedAt     time.Time              `json:"exported_at"`
+	Profile        models.User            `json:"profile"`
+	AnimeList      []models.UserAnimeList `json:"anime_list"` // Includes notes
+	Sessions       []models.Session       `json:"sessions"`
+	APIKeys        []models.APIKey        `json:"api_keys"`
+	LinkedAccounts []models.UserIdentity  `json:"linked_accounts"`
+	Activity       []activityEvent        `json:"activity"`
+}
+
+// collectExport loads every row owned by the user; secrets (password and token hashes, OAuth tokens) are hidden by the models' JSON tags
+func collectExport(user models.User) (*dataExport, error) {
+	export := &dataExport{ExportedAt: time.Now(), Profile: user}
+
+	if err := config.DB.Where("user_id = ?", user.ID).Order("id").Find(&export.AnimeList).Error; err != nil {
+		return nil, err
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("id").Find(&export.Sessions).Error; err != nil {
+		return nil, err
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("id").Find(&export.APIKeys).Error; err != nil {
+		return nil, err
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Order("id").Find(&export.LinkedAccounts).Error; err != nil {
+		return nil, err
+	}
+
+	export.Activity = buildActivity(export)
+	return export, nil
+}
+
+// buildActivity turns the stored timestamps into a chronological timeline
+func buildActivity(export *dataExport) []activityEvent {
+	user := export.Profile
+	events := []activityEvent{{At: user.CreatedAt, Type: "account_created"}}
+	if user.EmailVerifiedAt != nil {
+		events = append(events, activityEvent{At: *user.EmailVerifiedAt, Type: "email_verified", Detail: user.Email})
+	}
+	if user.TOTPEnabledAt != nil {
+		events = append(events, activityEvent{At: *user.TOTPEnabledAt, Type: "two_factor_enabled"})
+	}
+	for _, session := range export.Sessions {
+		events = append(events, activityEvent{At: session.CreatedAt, Type: "login", Detail: session.IPAddress})
+		if session.RevokedAt != nil {
+			events = append(events, activityEvent{At: *session.RevokedAt, Type: "session_ended", Detail: session.IPAddress})
+		}
+	}
+	for _, entry := range export.AnimeList {
+		detail := fmt.Sprintf("anime %d", entry.AnimeExternalID)
+		events = append(events, activityEvent{At: entry.CreatedAt, Type: "list_entry_added", Detail: detail})
+		if entry.UpdatedAt.After(entry.CreatedAt) {
+			events = append(events, activityEvent{At: entry.UpdatedAt, Type: "list_entry_updated", Detail: detail})
+		}
+	}
+	for _, key := range export.APIKeys {
+		events = append(events, activityEvent{At: key.CreatedAt, Type: "api_key_created", Detail: key.Name})
+	}
+	for _, identity := range export.LinkedAccounts {
+		events = append(events, activityEvent{At: identity.CreatedAt, Type: "account_linked", Detail: identity.Provider})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events
+}
+
+// ExportMyData downloads everything stored about the logged-in user,
+// as a ZIP of JSON files (default) or as one JSON document with ?format=json
+func ExportMyData(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "json" {
+		c.Error(apperr.BadRequest("Invalid format. Use zip or json"))
+		return
+	}
+
+	export, err := collectExport(user)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to export data", err))
+		return
+	}
+
+	filename := fmt.Sprintf("wawatch-export-%s-%s", user.Username, export.ExportedAt.Format("20060102"))
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"anime_list.json", export.AnimeList},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.APIKeys},
+		{"linked_accounts.json", export.LinkedAccounts},
+		{"activity.json", export.Activity},
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			log.Printf("Failed to write export of user %d: %v", user.ID, err)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			log.Printf("Failed to write export of user %d: %v", user.ID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export of user %d: %v", user.ID, err)
+	}
+}
+
+// DeleteMyAccount schedules the logged-in user's account for deletion after they re-authenticated.
+// All sessions and API keys stop working at once; logging in during the grace period cancels the deletion.
+func DeleteMyAccount(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var body struct {
+		Password string `json:"password"` // Not needed by accounts without a password
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.Error(apperr.Validation(err))
+		return
+	}
+	if appErr := reauthenticate(c, user, body.Password); appErr != nil {
+		c.Error(appErr)
+		return
+	}
+
+	now := time.Now()
+	grace := config.AppSettings.Account.DeletionGracePeriod
+	if grace == 0 {
+		if err := PurgeUser(c.Request.Context(), user.ID); err != nil {
+			c.Error(apperr.Internal("Failed to delete account", err))
+			return
+		}
+		clearAuthCookies(c)
+		c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+		return
+	}
+
+	scheduledAt := now.Add(grace)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("deletion_scheduled_at", scheduledAt).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", now).Error
+	})
+	if err != nil {
+		c.Error(apperr.Internal("Failed to schedule account deletion", err))
+		return
+	}
+
+	if user.Email != "" {
+		if err := mailClient.Send(c.Request.Context(), mailer.Message{
+			To:      user.Email,
+			Subject: "Your WaWatch account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour account and all its data will be deleted on %s.\nIf you change your mind, just log in again before then.\n",
+				user.Username, scheduledAt.UTC().Format("2 January 2006 15:04 MST")),
+		}); err != nil {
+			log.Printf("Failed to send deletion notice to user %d: %v", user.ID, err)
+		}
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message":               "Account scheduled for deletion. Log in again before the deletion date to cancel it.",
+		"deletion_scheduled_at": scheduledAt,
+	})
+}
+
+// PurgeUser hard-deletes the user and every row they own
+func PurgeUser(ctx context.Context, userID uint) error {
+	return config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteUserRows(tx, userID)
+	})
+}
+
+// deleteUserRows deletes the user and every row they own
+func deleteUserRows(tx *gorm.DB, userID uint) error {
+	owned := []interface{}{
+		&models.UserAnimeList{},
+		&models.Session{},
+		&models.UserToken{},
+		&models.UserRecoveryCode{},
+		&models.UserIdentity{},
+		&models.APIKey{},
+	}
+	for _, model := range owned {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Delete(&models.User{}, userID).Error
+}
+
+// purgeIfDue purges the user if their deletion is still due and reports whether it was.
+// The user row stays locked until the purge is done, so a login cancelling the deletion
+// either happens before and is seen here, or waits and finds the user gone.
+func purgeIfDue(ctx context.Context, userID uint, now time.Time) (bool, error) {
+	purged := false
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var due []uint
+		if err := tx.Unscoped().Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND (deletion_scheduled_at <= ? OR deleted_at IS NOT NULL)", userID, now).
+			Pluck("id", &due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		purged = true
+		return deleteUserRows(tx, userID)
+	})
+	return purged, err
+}
+
+// PurgeDeletedAccounts purges accounts whose grace period is over, as well as users
+// that were only soft-deleted by earlier versions. It is run periodically by the worker.
+func PurgeDeletedAccounts(ctx context.Context) error {
+	now := time.Now()
+	var ids []uint
+	if err := config.DB.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("deletion_scheduled_at <= ? OR deleted_at IS NOT NULL", now).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		purged, err := purgeIfDue(ctx, id, now)
+		if err != nil {
+			return fmt.Errorf("failed to purge user %d: %w", id, err)
+		}
+		if purged {
+			log.Printf("Purged user %d", id)
+		}
+	}
+	return nil
+}
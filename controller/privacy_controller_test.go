@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Test account deletion is refused without the current password
+func TestDeleteMyAccountWrongPassword(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right-password1"), bcrypt.MinCost)
+	mock.ExpectQuery(EscapeQuery(`SELECT "password" FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(string(hash)))
+
+	router.POST("/profile/delete", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 3}, Username: "leaving"})
+		DeleteMyAccount(c)
+	})
+
+	requestBody, _ := json.Marshal(gin.H{"password": "wrong-password1"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/profile/delete", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	// Nothing was scheduled or revoked
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the JSON export contains every section and no secrets
+func TestExportMyDataJSON(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM "user_anime_lists" WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "user_id", "anime_external_id", "status", "notes"}).
+			AddRow(1, now, now, 3, 21, "watching", "rewatching with friends"))
+	mock.ExpectQuery(`FROM "sessions" WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "refresh_token_hash", "ip_address", "created_at"}).
+			AddRow(1, 3, "secret-hash", "127.0.0.1", now))
+	mock.ExpectQuery(`FROM "api_keys" WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM "user_identities" WHERE user_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	router.GET("/profile/export", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 3, CreatedAt: now.Add(-time.Hour)}, Username: "leaving", Password: "hash"})
+		ExportMyData(c)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/profile/export?format=json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".json")
+	assert.NotContains(t, w.Body.String(), "secret-hash")
+
+	var responseBody map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	for _, section := range []string{"profile", "anime_list", "sessions", "api_keys", "linked_accounts", "activity"} {
+		assert.Contains(t, responseBody, section)
+	}
+	assert.Contains(t, string(responseBody["anime_list"]), "rewatching with friends")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the purge skips a user who logged in, cancelling the deletion, after the due users were listed
+func TestPurgeDeletedAccountsRechecksSchedule(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT "id" FROM "users" WHERE deletion_scheduled_at <= $1 OR deleted_at IS NOT NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+
+	// User 3 cancelled the deletion in between
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`SELECT "id" FROM "users" WHERE id = $1 AND (deletion_scheduled_at <= $2 OR deleted_at IS NOT NULL) FOR UPDATE`)).
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`SELECT "id" FROM "users" WHERE id = $1 AND (deletion_scheduled_at <= $2 OR deleted_at IS NOT NULL) FOR UPDATE`)).
+		WithArgs(4, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	for _, table := range []string{"user_anime_lists", "sessions", "user_tokens", "user_recovery_codes", "user_identities", "api_keys"} {
+		mock.ExpectExec(EscapeQuery(`DELETE FROM "` + table + `" WHERE user_id = $1`)).
+			WithArgs(4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(EscapeQuery(`DELETE FROM "users" WHERE "users"."id" = $1`)).
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, PurgeDeletedAccounts(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
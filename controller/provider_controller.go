@@ -6,23 +6,23 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/vrstep/wawatch-backend/apperr"
 	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
 )
 
-// UpdateWatchProvider updates an existing watch provider entry
-// TODO: Add admin authorization check if needed
+// UpdateWatchProvider updates an existing watch provider entry (moderators and admins)
 func UpdateWatchProvider(c *gin.Context) {
 	providerIDParam := c.Param("provider_id")
 	providerID, err := uuid.Parse(providerIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID format"})
+		c.Error(apperr.BadRequest("Invalid provider ID format"))
 		return
 	}
 
 	var provider models.WatchProvider
 	if err := config.DB.First(&provider, "id = ?", providerID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Watch provider not found"})
+		c.Error(apperr.NotFound("Watch provider not found"))
 		return
 	}
 
@@ -37,7 +37,7 @@ func UpdateWatchProvider(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		c.Error(apperr.Validation(err))
 		return
 	}
 
@@ -68,7 +68,7 @@ func UpdateWatchProvider(c *gin.Context) {
 	if updated {
 		provider.LastUpdated = time.Now() // Update the timestamp
 		if err := config.DB.Save(&provider).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider"})
+			c.Error(apperr.Internal("Failed to update provider", err))
 			return
 		}
 	}
@@ -76,26 +76,25 @@ func UpdateWatchProvider(c *gin.Context) {
 	c.JSON(http.StatusOK, provider)
 }
 
-// DeleteWatchProvider deletes a watch provider entry
-// TODO: Add admin authorization check if needed
+// DeleteWatchProvider deletes a watch provider entry (moderators and admins)
 func DeleteWatchProvider(c *gin.Context) {
 	providerIDParam := c.Param("provider_id")
 	providerID, err := uuid.Parse(providerIDParam)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID format"})
+		c.Error(apperr.BadRequest("Invalid provider ID format"))
 		return
 	}
 
 	var provider models.WatchProvider
 	// Ensure the provider exists before attempting deletion
 	if err := config.DB.First(&provider, "id = ?", providerID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Watch provider not found"})
+		c.Error(apperr.NotFound("Watch provider not found"))
 		return
 	}
 
 	// Perform the delete operation
 	if err := config.DB.Delete(&provider).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete provider"})
+		c.Error(apperr.Internal("Failed to delete provider", err))
 		return
 	}
 
@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+var errInvalidTwoFactorCode = apperr.InvalidFields(validation.FieldError{Field: "code", Code: "invalid", Message: "is not a valid code"})
+
+// respondMFAChallenge answers a correct password of a 2FA account with a token for the second step
+func respondMFAChallenge(c *gin.Context, user models.User) {
+	ttl := config.AppSettings.Auth.MFATokenTTL
+	token, err := auth.IssuePurposeToken(auth.PurposeMFA, strconv.FormatUint(uint64(user.ID), 10), ttl)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate token", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Two-factor authentication required",
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(ttl.Seconds()),
+	})
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and issues a fresh set, returned in plain text
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.UserRecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, models.UserRecoveryCode{UserID: userID, CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code))})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// consumeRecoveryCode marks a matching unused recovery code as used and reports whether there was one
+func consumeRecoveryCode(userID uint, code string) (bool, error) {
+	result := config.DB.Model(&models.UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, auth.HashToken(auth.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// useTOTPCode checks a code and records its step; the conditional update makes every code single-use
+func useTOTPCode(user models.User, code string) (bool, error) {
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	result := config.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// reauthenticate is required before sensitive account changes: the password is entered again.
+// Accounts without a password, created by logging in with AniList, must have logged in within
+// the last ReauthWindow instead, so the client sends them through the AniList login first.
+func reauthenticate(c *gin.Context, user models.User, password string) *apperr.Error {
+	var stored models.User
+	if err := config.DB.Select("password").First(&stored, user.ID).Error; err != nil {
+		return apperr.Internal("Failed to load user", err)
+	}
+
+	if stored.Password == "" {
+		sessionInterface, exists := c.Get("session")
+		if !exists {
+			return apperr.Unauthorized("Log in again to continue")
+		}
+		session := sessionInterface.(models.Session)
+		if time.Since(session.CreatedAt) > config.AppSettings.Auth.ReauthWindow {
+			return apperr.Unauthorized("Log in again to continue")
+		}
+		return nil
+	}
+
+	if password == "" {
+		return apperr.InvalidFields(validation.FieldError{Field: "password", Code: "required", Message: "is required"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte(password)) != nil {
+		return apperr.Unauthorized("Invalid password")
+	}
+	return nil
+}
+
+// SetupTwoFactor starts TOTP enrollment: it stores a new secret and returns it with the otpauth URI for a QR code.
+// Nothing changes for logins until the secret is confirmed.
+func SetupTwoFactor(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	if user.TwoFactorEnabled() {
+		c.Error(apperr.Conflict("Two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate secret", err))
+		return
+	}
+	if err := config.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("totp_secret", secret).Error; err != nil {
+		c.Error(apperr.Internal("Failed to start two-factor setup", err))
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_url": auth.TOTPURI(secret, config.AppSettings.Auth.TOTPIssuer, account),
+	})
+}
+
+// ConfirmTwoFactor enables 2FA once the user proves their app produces valid codes.
+// The recovery codes are returned here and never again.
+func ConfirmTwoFactor(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		c.Error(apperr.Conflict("Two-factor authentication is already enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.Error(apperr.BadRequest("Two-factor setup has not been started"))
+		return
+	}
+
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, body.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		c.Error(errInvalidTwoFactorCode)
+		return
+	}
+
+	var codes []string
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.Error(apperr.Internal("Failed to enable two-factor authentication", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off after the user re-authenticated
+func DisableTwoFactor(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var body struct {
+		Password string `json:"password"` // Not needed by accounts without a password
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	if !user.TwoFactorEnabled() {
+		c.Error(apperr.BadRequest("Two-factor authentication is not enabled"))
+		return
+	}
+	if appErr := reauthenticate(c, user, body.Password); appErr != nil {
+		c.Error(appErr)
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.UserRecoveryCode{}).Error
+	})
+	if err != nil {
+		c.Error(apperr.Internal("Failed to disable two-factor authentication", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after the user re-authenticated
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.Error(apperr.Unauthorized("Not authenticated"))
+		return
+	}
+	user := userInterface.(models.User)
+
+	var body struct {
+		Password string `json:"password"` // Not needed by accounts without a password
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	if !user.TwoFactorEnabled() {
+		c.Error(apperr.BadRequest("Two-factor authentication is not enabled"))
+		return
+	}
+	if appErr := reauthenticate(c, user, body.Password); appErr != nil {
+		c.Error(appErr)
+		return
+	}
+
+	var codes []string
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate recovery codes", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// VerifyTwoFactor is the second login step: it trades the MFA token from Login plus
+// a TOTP or recovery code for a session. Wrong codes count towards the account lockout.
+func VerifyTwoFactor(c *gin.Context) {
+	var body struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code" binding:"required_without=RecoveryCode"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceName   string `json:"device_name"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	subject, err := auth.ParsePurposeToken(body.MFAToken, auth.PurposeMFA)
+	if err != nil {
+		c.Error(apperr.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+	userID, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		c.Error(apperr.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, userID).Error; err != nil || !user.TwoFactorEnabled() {
+		c.Error(apperr.Unauthorized("Invalid or expired MFA token"))
+		return
+	}
+
+	now := time.Now()
+	if user.IsLocked(now) {
+		c.Error(errInvalidTwoFactorCode)
+		return
+	}
+
+	var valid bool
+	if body.Code != "" {
+		valid, err = useTOTPCode(user, body.Code)
+	} else {
+		valid, err = consumeRecoveryCode(user.ID, body.RecoveryCode)
+	}
+	if err != nil {
+		c.Error(apperr.Internal("Failed to verify code", err))
+		return
+	}
+	if !valid {
+		if err := recordFailedLogin(user, now); err != nil {
+			log.Printf("Failed to record failed login for user %d: %v", user.ID, err)
+		}
+		c.Error(errInvalidTwoFactorCode)
+		return
+	}
+
+	completeLogin(c, user, body.DeviceName)
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Test Login of a 2FA account returns a challenge instead of a session
+func TestLoginRequiresSecondFactor(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right-password1"), bcrypt.MinCost)
+	now := time.Now()
+	columns := append(append([]string{}, userColumns...), "totp_secret", "totp_enabled_at")
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE username = $1`)).
+		WithArgs("careful", 1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(5, now, now, nil, "careful", string(hash), nil, nil, "user", "default.jpg", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", now))
+
+	router.POST("/login", Login)
+
+	requestBody, _ := json.Marshal(gin.H{"username": "careful", "password": "right-password1"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var responseBody struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.True(t, responseBody.MFARequired)
+	assert.Empty(t, responseBody.Token)
+
+	// The challenge is not an access token
+	subject, err := auth.ParsePurposeToken(responseBody.MFAToken, auth.PurposeMFA)
+	assert.NoError(t, err)
+	assert.Equal(t, "5", subject)
+	_, err = auth.ParseAccessToken(responseBody.MFAToken)
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test the second step refuses tokens that are not MFA challenges
+func TestVerifyTwoFactorRejectsAccessToken(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	accessToken, _, _ := auth.IssueAccessToken(5, 1)
+
+	router.POST("/auth/2fa/verify", VerifyTwoFactor)
+
+	requestBody, _ := json.Marshal(gin.H{"mfa_token": accessToken, "code": "123456"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/2fa/verify", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test accounts without a password re-authenticate by a recent login, and others by their password
+func TestReauthenticate(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right-password1"), bcrypt.MinCost)
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		session  *models.Session
+		want     int
+	}{
+		{"recent login without password", "", "", &models.Session{Model: gorm.Model{CreatedAt: time.Now().Add(-time.Minute)}}, http.StatusNoContent},
+		{"old login without password", "", "", &models.Session{Model: gorm.Model{CreatedAt: time.Now().Add(-time.Hour)}}, http.StatusUnauthorized},
+		{"api key without password", "", "", nil, http.StatusUnauthorized},
+		{"right password", string(hash), "right-password1", nil, http.StatusNoContent},
+		{"wrong password", string(hash), "wrong-password1", &models.Session{Model: gorm.Model{CreatedAt: time.Now()}}, http.StatusUnauthorized},
+		{"missing password", string(hash), "", &models.Session{Model: gorm.Model{CreatedAt: time.Now()}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, cleanup := SetupTestDB(t)
+			defer cleanup()
+			router := SetupGin()
+
+			mock.ExpectQuery(EscapeQuery(`SELECT "password" FROM "users" WHERE "users"."id" = $1`)).
+				WithArgs(3, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(tt.stored))
+
+			router.POST("/reauth", func(c *gin.Context) {
+				if tt.session != nil {
+					c.Set("session", *tt.session)
+				}
+				if appErr := reauthenticate(c, models.User{Model: gorm.Model{ID: 3}}, tt.password); appErr != nil {
+					c.Error(appErr)
+					return
+				}
+				c.Status(http.StatusNoContent)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/reauth", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
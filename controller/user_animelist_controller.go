@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
 	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
 )
@@ -14,7 +15,7 @@ import (
 func GetUserAnimeList(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
@@ -31,11 +32,17 @@ func GetUserAnimeList(c *gin.Context) {
 	var list []models.UserAnimeList
 	query.Find(&list)
 
-	// Fetch anime details for each list entry
+	// Fetch anime details for all list entries at once
+	animes, err := loadAnimeCaches(c.Request.Context(), listAnimeIDs(list))
+	if err != nil {
+		c.Error(apperr.Internal("Failed to load anime", err))
+		return
+	}
+
 	var result []gin.H
 	for _, item := range list {
-		var anime models.AnimeCache
-		if err := config.DB.First(&anime, item.AnimeExternalID).Error; err != nil {
+		anime, ok := animes[item.AnimeExternalID]
+		if !ok {
 			// Skip entries where we can't find the anime cache
 			continue
 		}
@@ -62,11 +69,20 @@ func GetUserAnimeList(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// listAnimeIDs returns the AniList IDs of the list entries
+func listAnimeIDs(list []models.UserAnimeList) []int {
+	ids := make([]int, len(list))
+	for i, item := range list {
+		ids[i] = item.AnimeExternalID
+	}
+	return ids
+}
+
 // AddToAnimeList adds or updates an anime in the user's list
 func AddToAnimeList(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
@@ -84,7 +100,7 @@ func AddToAnimeList(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation(err))
 		return
 	}
 
@@ -99,7 +115,7 @@ func AddToAnimeList(c *gin.Context) {
 	}
 
 	if !validStatuses[input.Status] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		c.Error(apperr.BadRequest("Invalid status"))
 		return
 	}
 
@@ -107,15 +123,17 @@ func AddToAnimeList(c *gin.Context) {
 	var animeCache models.AnimeCache
 	if err := config.DB.First(&animeCache, input.AnimeID).Error; err != nil {
 		// Fetch from AniList if not in cache
-		anime, err := anilistClient.GetAnimeByID(input.AnimeID)
+		anime, err := anilistClient.GetAnimeByID(c.Request.Context(), input.AnimeID)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Anime not found"})
+			c.Error(anilistError("Failed to fetch anime details", err))
 			return
 		}
 
-		// Create cache entry
-		animeCache = anime.ToAnimeCache()
-		config.DB.Create(&animeCache)
+		// Create cache entry, the list entry references it
+		if err := storeAnimeDetails(c.Request.Context(), anime); err != nil {
+			c.Error(apperr.Internal("Failed to cache anime", err))
+			return
+		}
 	}
 
 	// Check if entry already exists
@@ -133,7 +151,7 @@ func AddToAnimeList(c *gin.Context) {
 		existingEntry.RewatchCount = input.RewatchCount
 
 		if err := config.DB.Save(&existingEntry).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update list entry"})
+			c.Error(apperr.Internal("Failed to update list entry", err))
 			return
 		}
 
@@ -158,7 +176,7 @@ func AddToAnimeList(c *gin.Context) {
 	}
 
 	if err := config.DB.Create(&newEntry).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to list"})
+		c.Error(apperr.Internal("Failed to add to list", err))
 		return
 	}
 
@@ -172,26 +190,26 @@ func AddToAnimeList(c *gin.Context) {
 func UpdateListEntry(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
 	userModel := user.(models.User)
 	entryID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+		c.Error(apperr.BadRequest("Invalid entry ID"))
 		return
 	}
 
 	var entry models.UserAnimeList
 	if err := config.DB.First(&entry, entryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		c.Error(apperr.NotFound("Entry not found"))
 		return
 	}
 
 	// Verify ownership
 	if entry.UserID != userModel.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to edit this entry"})
+		c.Error(apperr.Forbidden("Not authorized to edit this entry"))
 		return
 	}
 
@@ -206,7 +224,7 @@ func UpdateListEntry(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Validation(err))
 		return
 	}
 
@@ -223,7 +241,7 @@ func UpdateListEntry(c *gin.Context) {
 		}
 
 		if !validStatuses[input.Status] {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			c.Error(apperr.BadRequest("Invalid status"))
 			return
 		}
 
@@ -255,7 +273,7 @@ func UpdateListEntry(c *gin.Context) {
 	}
 
 	if err := config.DB.Save(&entry).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update entry"})
+		c.Error(apperr.Internal("Failed to update entry", err))
 		return
 	}
 
@@ -270,14 +288,14 @@ func UpdateListEntry(c *gin.Context) {
 func GetAnimeInUserList(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
 	userModel := user.(models.User)
 	animeID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid anime ID"})
+		c.Error(apperr.BadRequest("Invalid anime ID"))
 		return
 	}
 
@@ -301,31 +319,31 @@ func GetAnimeInUserList(c *gin.Context) {
 func DeleteListEntry(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
 	userModel := user.(models.User)
 	entryID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entry ID"})
+		c.Error(apperr.BadRequest("Invalid entry ID"))
 		return
 	}
 
 	var entry models.UserAnimeList
 	if err := config.DB.First(&entry, entryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		c.Error(apperr.NotFound("Entry not found"))
 		return
 	}
 
 	// Verify ownership
 	if entry.UserID != userModel.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to delete this entry"})
+		c.Error(apperr.Forbidden("Not authorized to delete this entry"))
 		return
 	}
 
 	if err := config.DB.Delete(&entry).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete entry"})
+		c.Error(apperr.Internal("Failed to delete entry", err))
 		return
 	}
 
@@ -336,14 +354,14 @@ func DeleteListEntry(c *gin.Context) {
 func GetUserAnimeListStats(c *gin.Context) {
 	userInterface, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 	userModel := userInterface.(models.User)
 
 	var list []models.UserAnimeList
 	if err := config.DB.Where("user_id = ?", userModel.ID).Find(&list).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve anime list"})
+		c.Error(apperr.Internal("Failed to retrieve anime list", err))
 		return
 	}
 
@@ -71,3 +71,46 @@ func TestGetUserAnimeListStats(t *testing.T) {
 
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+// Test GetUserAnimeList fetches every uncached anime with one batched AniList call
+func TestGetUserAnimeListFetchesMissingAnime(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	mockAPI := new(MockAniListClient)
+	SetAniListClient(mockAPI)
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "user_anime_lists" WHERE user_id = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "anime_external_id", "status"}).
+			AddRow(1, 1, 101, models.Watching).
+			AddRow(2, 1, 102, models.Completed).
+			AddRow(3, 1, 103, models.Planned))
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE id IN ($1,$2,$3)`)).
+		WithArgs(101, 102, 103).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(101, "Cached Anime"))
+
+	fetched := []models.AnimeDetails{{ID: 102}, {ID: 103}}
+	fetched[0].Title.English = "Fetched Anime"
+	fetched[1].Title.English = "Other Fetched Anime"
+	mockAPI.On("GetAnimeByIDs", []int{102, 103}).Return(fetched, nil)
+
+	expectStoreAnime(mock, 102, 103)
+
+	router.GET("/animelist", func(c *gin.Context) {
+		c.Set("user", models.User{Model: gorm.Model{ID: 1}})
+		GetUserAnimeList(c)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/animelist", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var responseBody []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	assert.Len(t, responseBody, 3)
+	assert.Equal(t, "Fetched Anime", responseBody[1]["anime"].(map[string]interface{})["title"])
+	mockAPI.AssertExpectations(t)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
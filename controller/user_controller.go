@@ -1,89 +1,221 @@
 package controller
 
 import (
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
 	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// userUniqueFields maps the unique constraints on users to the field they guard
+var userUniqueFields = map[string]string{
+	"users_username_key": "username",
+	"users_email_key":    "email",
+}
+
+// userWriteError turns a failed insert/update of a user into a 409 naming the
+// taken field, or an internal error with the given message
+func userWriteError(message string, err error) *apperr.Error {
+	constraint, ok := validation.UniqueViolation(err)
+	if !ok {
+		return apperr.Internal(message, err)
+	}
+
+	field, known := userUniqueFields[constraint]
+	if !known {
+		field = "body"
+	}
+	return apperr.Conflict("Already taken", validation.FieldError{Field: field, Code: "taken", Message: "is already taken"})
+}
+
+// GetUsers lists all users (admin only)
 func GetUsers(c *gin.Context) {
 	users := []models.User{}
-	config.DB.Find(&users)
-	c.JSON(200, users)
+	if err := config.DB.Order("id").Find(&users).Error; err != nil {
+		c.Error(apperr.Internal("Failed to retrieve users", err))
+		return
+	}
+	c.JSON(http.StatusOK, users)
 }
 
+// CreateUser creates an account with any role (admin only)
 func CreateUser(c *gin.Context) {
-	user := models.User{}
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	var input struct {
+		Username       string `json:"username" binding:"required,username"`
+		Password       string `json:"password" binding:"required,password"`
+		Email          string `json:"email" binding:"omitempty,email"`
+		Role           string `json:"role" binding:"omitempty,oneof=user moderator admin"`
+		ProfilePicture string `json:"profile_picture" binding:"omitempty,http_url"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperr.Validation(err))
+		return
+	}
+
+	role := auth.NormalizeRole(input.Role)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), 10)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to hash password", err))
+		return
+	}
+
+	user := models.User{
+		Username:       input.Username,
+		Password:       string(hash),
+		Email:          input.Email,
+		Role:           role,
+		ProfilePicture: input.ProfilePicture,
+	}
+	if err := config.DB.Create(&user).Error; err != nil {
+		c.Error(userWriteError("Failed to create user", err))
 		return
 	}
-	config.DB.Create(&user)
-	c.JSON(200, user)
+	c.JSON(http.StatusCreated, user)
 }
 
+// DeleteUser deletes an account and signs it out everywhere (admin only)
 func DeleteUser(c *gin.Context) {
 	user := models.User{}
 	id := c.Param("id")
 	if err := config.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		c.JSON(404, gin.H{"error": "User not found"})
+		c.Error(apperr.NotFound("User not found"))
+		return
+	}
+
+	if admin, ok := c.Get("user"); ok && admin.(models.User).ID == user.ID {
+		c.Error(apperr.BadRequest("You cannot delete your own account here"))
+		return
+	}
+
+	// Admin deletions skip the grace period and remove all of the user's data at once
+	if err := PurgeUser(c.Request.Context(), user.ID); err != nil {
+		c.Error(apperr.Internal("Failed to delete user", err))
 		return
 	}
-	config.DB.Delete(&user)
-	c.JSON(200, gin.H{"message": "User deleted"})
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
+// UpdateUser changes the whitelisted fields of an account (admin only)
 func UpdateUser(c *gin.Context) {
 	user := models.User{}
 	id := c.Param("id")
 	if err := config.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		c.JSON(404, gin.H{"error": "User not found"})
+		c.Error(apperr.NotFound("User not found"))
 		return
 	}
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+
+	// Use pointers to only update fields that are actually sent
+	var input struct {
+		Email          *string `json:"email" binding:"omitnil,email"`
+		Password       *string `json:"password" binding:"omitnil,password"`
+		Role           *string `json:"role" binding:"omitnil,oneof=user moderator admin"`
+		ProfilePicture *string `json:"profile_picture" binding:"omitnil,http_url"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.Error(apperr.Validation(err))
 		return
 	}
-	config.DB.Save(&user)
-	c.JSON(200, user)
+
+	// Changing credentials or privileges invalidates existing logins
+	revokeSessions := false
+
+	// Only write the fields that were sent; saving the whole row would turn a missing email into ''
+	updates := map[string]interface{}{}
+	if input.Email != nil && *input.Email != user.Email {
+		updates["email"] = *input.Email
+		// The new address has not been confirmed by its owner
+		updates["email_verified_at"] = nil
+	}
+	if input.ProfilePicture != nil {
+		updates["profile_picture"] = *input.ProfilePicture
+	}
+	if input.Role != nil {
+		if admin, ok := c.Get("user"); ok && admin.(models.User).ID == user.ID && *input.Role != models.RoleAdmin {
+			c.Error(apperr.BadRequest("You cannot remove your own admin role"))
+			return
+		}
+		if auth.NormalizeRole(user.Role) != *input.Role {
+			revokeSessions = true
+		}
+		updates["role"] = *input.Role
+	}
+	if input.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*input.Password), 10)
+		if err != nil {
+			c.Error(apperr.Internal("Failed to hash password", err))
+			return
+		}
+		updates["password"] = string(hash)
+		revokeSessions = true
+	}
+
+	if len(updates) > 0 {
+		if err := config.DB.Model(&user).Updates(updates).Error; err != nil {
+			c.Error(userWriteError("Failed to update user", err))
+			return
+		}
+	}
+	if _, ok := updates["email"]; ok {
+		user.Email = *input.Email
+		user.EmailVerifiedAt = nil
+	}
+	if input.ProfilePicture != nil {
+		user.ProfilePicture = *input.ProfilePicture
+	}
+	if input.Role != nil {
+		user.Role = *input.Role
+	}
+	if revokeSessions {
+		if err := revokeAllSessions(user.ID); err != nil {
+			c.Error(apperr.Internal("Failed to revoke sessions", err))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, user)
 }
 
+// GetUser returns a single account (admin only)
 func GetUser(c *gin.Context) {
 	user := models.User{}
 	id := c.Param("id")
 	if err := config.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		c.JSON(404, gin.H{"error": "User not found"})
+		c.Error(apperr.NotFound("User not found"))
 		return
 	}
-	c.JSON(200, user)
+	c.JSON(http.StatusOK, user)
 }
 
 func Signup(c *gin.Context) {
 	var body struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Email    string `json:"email,omitempty"` // Optional email field
+		Username string `json:"username" binding:"required,username"`
+		Password string `json:"password" binding:"required,password"`
+		Email    string `json:"email,omitempty" binding:"omitempty,email"` // Optional email field
 	}
 
-	if err := c.Bind(&body); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(apperr.Validation(err))
 		return
 	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), 10)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to hash password"})
+		c.Error(apperr.Internal("Failed to hash password", err))
 		return
 	}
 
 	user := models.User{
 		Username: body.Username,
 		Password: string(hash),
+		Role:     models.RoleUser,
 	}
 
 	// Only set email if provided
@@ -92,54 +224,115 @@ func Signup(c *gin.Context) {
 	}
 
 	if err := config.DB.Create(&user).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create user", "details": err.Error()})
+		c.Error(userWriteError("Failed to create user", err))
 		return
 	}
 
+	if err := sendVerificationEmail(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	c.JSON(200, gin.H{"message": "User created successfully"})
 }
 
+// dummyPasswordHash is checked when the username is unknown or locked, so those
+// answers take as long as a wrong password and do not reveal which accounts exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), 10)
+
+// errInvalidCredentials is the only answer a failed login gets
+var errInvalidCredentials = apperr.Unauthorized("Invalid username or password")
+
 func Login(c *gin.Context) {
 	var body struct {
-		Username string
-		Password string
+		Username   string
+		Password   string
+		DeviceName string `json:"device_name"` // Optional label shown in the session list
 	}
 
-	if c.Bind(&body) != nil {
-		c.JSON(400, gin.H{"error": "Invalid input"})
+	if c.ShouldBind(&body) != nil {
+		c.Error(apperr.BadRequest("Invalid input"))
 		return
 	}
 
 	user := models.User{}
 	if err := config.DB.Where("username = ?", body.Username).First(&user).Error; err != nil {
-		c.JSON(404, gin.H{"error": "User not found"})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(body.Password))
+		c.Error(errInvalidCredentials)
+		return
+	}
+
+	// A locked account answers like a wrong password, even for the right one
+	now := time.Now()
+	if user.IsLocked(now) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(body.Password))
+		c.Error(errInvalidCredentials)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
-		c.JSON(401, gin.H{"error": "Invalid password"})
+		if err := recordFailedLogin(user, now); err != nil {
+			log.Printf("Failed to record failed login for user %d: %v", user.ID, err)
+		}
+		c.Error(errInvalidCredentials)
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": user.ID,
-		"exp": jwt.TimeFunc().Add(time.Hour * 72).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte("secret"))
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate token"})
+	// With 2FA on, the password only earns a challenge token for /auth/2fa/verify
+	if user.TwoFactorEnabled() {
+		respondMFAChallenge(c, user)
 		return
 	}
 
-	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Auth", tokenString, 3600, "/", "localhost", false, true)
+	completeLogin(c, user, body.DeviceName)
+}
 
-	c.JSON(200, gin.H{
-		"token": tokenString,
-		"user":  user,
-	})
+// completeLogin starts a session once every factor was checked; it is the last step of every login
+func completeLogin(c *gin.Context, user models.User, deviceName string) {
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := config.DB.Model(&user).Updates(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"locked_until":          nil,
+		}).Error; err != nil {
+			log.Printf("Failed to reset failed logins for user %d: %v", user.ID, err)
+		}
+	}
 
-	c.JSON(200, gin.H{"message": "Login successful"})
+	// Logging in during the grace period cancels a requested account deletion
+	deletionCancelled := false
+	if user.DeletionScheduledAt != nil {
+		if err := config.DB.Model(&user).Update("deletion_scheduled_at", nil).Error; err != nil {
+			c.Error(apperr.Internal("Failed to cancel account deletion", err))
+			return
+		}
+		user.DeletionScheduledAt = nil
+		deletionCancelled = true
+	}
+
+	response, err := issueSession(c, user, deviceName)
+	if err != nil {
+		c.Error(apperr.Internal("Failed to generate token", err))
+		return
+	}
+
+	response["message"] = "Login successful"
+	if deletionCancelled {
+		response["deletion_cancelled"] = true
+	}
+	response["user"] = user
+	c.JSON(200, response)
+}
+
+// recordFailedLogin counts a failed login and locks the account once there were too many in a row
+func recordFailedLogin(user models.User, now time.Time) error {
+	updates := map[string]interface{}{
+		// Incremented in SQL so concurrent failures are all counted
+		"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+	}
+	if lockout := auth.LockoutDuration(user.FailedLoginAttempts+1, config.AppSettings.Auth); lockout > 0 {
+		updates["locked_until"] = now.Add(lockout)
+		log.Printf("User %d locked for %s after %d failed logins", user.ID, lockout, user.FailedLoginAttempts+1)
+	}
+	return config.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error
 }
 
 func Validate(c *gin.Context) {
@@ -154,7 +347,7 @@ func Validate(c *gin.Context) {
 func GetMyProfile(c *gin.Context) {
 	userInterface, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 
@@ -162,13 +355,14 @@ func GetMyProfile(c *gin.Context) {
 
 	// Return user data, excluding the password hash
 	c.JSON(http.StatusOK, gin.H{
-		"id":              user.ID,
-		"username":        user.Username,
-		"email":           user.Email,
-		"role":            user.Role,
-		"profile_picture": user.ProfilePicture,
-		"created_at":      user.CreatedAt,
-		"updated_at":      user.UpdatedAt,
+		"id":                user.ID,
+		"username":          user.Username,
+		"email":             user.Email,
+		"email_verified_at": user.EmailVerifiedAt,
+		"role":              user.Role,
+		"profile_picture":   user.ProfilePicture,
+		"created_at":        user.CreatedAt,
+		"updated_at":        user.UpdatedAt,
 	})
 }
 
@@ -176,53 +370,72 @@ func GetMyProfile(c *gin.Context) {
 func UpdateMyProfile(c *gin.Context) {
 	userInterface, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		c.Error(apperr.Unauthorized("Not authenticated"))
 		return
 	}
 	currentUser := userInterface.(models.User)
 
 	var input struct {
-		Email          *string `json:"email"`
-		ProfilePicture *string `json:"profile_picture"`
+		Email          *string `json:"email" binding:"omitnil,email"`
+		ProfilePicture *string `json:"profile_picture" binding:"omitnil,http_url"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		c.Error(apperr.Validation(err))
 		return
 	}
 
 	// If no fields provided, return error
 	if input.Email == nil && input.ProfilePicture == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		c.Error(apperr.BadRequest("No fields to update"))
 		return
 	}
 
 	var userToUpdate models.User
 	if err := config.DB.First(&userToUpdate, currentUser.ID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(apperr.NotFound("User not found"))
 		return
 	}
 
-	if input.Email != nil {
-		userToUpdate.Email = *input.Email
+	// Only write the fields that were sent
+	updates := map[string]interface{}{}
+	emailChanged := false
+	if input.Email != nil && *input.Email != userToUpdate.Email {
+		updates["email"] = *input.Email
+		// A new address has to be confirmed again
+		updates["email_verified_at"] = nil
+		emailChanged = true
 	}
 	if input.ProfilePicture != nil {
-		userToUpdate.ProfilePicture = *input.ProfilePicture
+		updates["profile_picture"] = *input.ProfilePicture
 	}
 
-	if err := config.DB.Save(&userToUpdate).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile", "details": err.Error()})
-		return
+	if len(updates) > 0 {
+		if err := config.DB.Model(&userToUpdate).Updates(updates).Error; err != nil {
+			c.Error(userWriteError("Failed to update profile", err))
+			return
+		}
+	}
+	if emailChanged {
+		userToUpdate.Email = *input.Email
+		userToUpdate.EmailVerifiedAt = nil
+	}
+
+	if emailChanged {
+		if err := sendVerificationEmail(c.Request.Context(), userToUpdate); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userToUpdate.ID, err)
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":              userToUpdate.ID,
-		"username":        userToUpdate.Username,
-		"email":           userToUpdate.Email,
-		"role":            userToUpdate.Role,
-		"profile_picture": userToUpdate.ProfilePicture,
-		"created_at":      userToUpdate.CreatedAt,
-		"updated_at":      userToUpdate.UpdatedAt,
+		"id":                userToUpdate.ID,
+		"username":          userToUpdate.Username,
+		"email":             userToUpdate.Email,
+		"email_verified_at": userToUpdate.EmailVerifiedAt,
+		"role":              userToUpdate.Role,
+		"profile_picture":   userToUpdate.ProfilePicture,
+		"created_at":        userToUpdate.CreatedAt,
+		"updated_at":        userToUpdate.UpdatedAt,
 	})
 }
 
@@ -232,7 +445,7 @@ func GetUserPublicAnimeList(c *gin.Context) {
 
 	var targetUser models.User
 	if err := config.DB.Where("username = ?", username).First(&targetUser).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(apperr.NotFound("User not found"))
 		return
 	}
 
@@ -240,10 +453,15 @@ func GetUserPublicAnimeList(c *gin.Context) {
 	var list []models.UserAnimeList
 	config.DB.Where("user_id = ?", targetUser.ID).Find(&list)
 
+	animes, err := loadAnimeCaches(c.Request.Context(), listAnimeIDs(list))
+	if err != nil {
+		c.Error(apperr.Internal("Failed to load anime", err))
+		return
+	}
+
 	var result []gin.H
 	for _, item := range list {
-		var anime models.AnimeCache
-		if err := config.DB.First(&anime, item.AnimeExternalID).Error; err == nil {
+		if anime, ok := animes[item.AnimeExternalID]; ok {
 			result = append(result, gin.H{
 				// Only include publicly relevant fields
 				"status":   item.Status,
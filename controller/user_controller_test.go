@@ -10,8 +10,12 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/validation"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -80,25 +84,35 @@ func TestUpdateMyProfile(t *testing.T) {
 	// Input Data
 	updateInput := gin.H{
 		"email":           "new@test.com",
-		"profile_picture": "new_pic.jpg",
+		"profile_picture": "https://img.example.com/new_pic.jpg",
 	}
 	requestBody, _ := json.Marshal(updateInput)
 
 	// Mock DB Expectations
 	// 1. Expect GORM to fetch the user first
-	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL ORDER BY "users"\."id" LIMIT 1`).
-		WithArgs(int64(mockUserID)).
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL ORDER BY "users"\."id" LIMIT \$2`).
+		WithArgs(mockUserID, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "email", "role", "profile_picture"}).
 			AddRow(mockUser.ID, mockUser.CreatedAt, mockUser.UpdatedAt, nil,
 				mockUser.Username, mockUser.Password, mockUser.Email, mockUser.Role, mockUser.ProfilePicture))
 
 	// 2. Expect GORM to begin a transaction, update, and commit
 	mock.ExpectBegin()
-	mock.ExpectExec(`UPDATE "users" SET "email"=\$1,"profile_picture"=\$2,"updated_at"=\$3 WHERE "users"\."deleted_at" IS NULL AND "id" = \$4`).
-		WithArgs(updateInput["email"], updateInput["profile_picture"], sqlmock.AnyArg(), int64(mockUserID)).
+	mock.ExpectExec(`UPDATE "users" SET "email"=\$1,"email_verified_at"=\$2,"profile_picture"=\$3,"updated_at"=\$4 WHERE "users"\."deleted_at" IS NULL AND "id" = \$5`).
+		WithArgs(updateInput["email"], nil, updateInput["profile_picture"], sqlmock.AnyArg(), mockUserID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
+	// 3. The new address gets a verification link
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "user_tokens" SET "used_at"=\$1`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
 	// Setup Route and Handler
 	router.PUT("/profile", func(c *gin.Context) {
 		// Set the user ID in context (as middleware would do)
@@ -126,6 +140,60 @@ func TestUpdateMyProfile(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+// Test an admin edit only writes the fields sent, so a user without an email keeps NULL
+func TestUpdateUserWritesOnlySentFields(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE id = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs("2", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "role"}).AddRow(2, "anilistfan", nil, "user"))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "users" SET "profile_picture"=$1,"updated_at"=$2 WHERE "users"."deleted_at" IS NULL AND "id" = $3`)).
+		WithArgs("https://img.example.com/pic.jpg", sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	router.PUT("/admin/users/:id", UpdateUser)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/admin/users/2", bytes.NewBufferString(`{"profile_picture":"https://img.example.com/pic.jpg"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"profile_picture":"https://img.example.com/pic.jpg"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test an admin changing the email resets its verification
+func TestUpdateUserEmailClearsVerification(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE id = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "email_verified_at", "role"}).
+			AddRow(3, "someone", "old@test.com", time.Now(), "user"))
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "users" SET "email"=$1,"email_verified_at"=$2,"updated_at"=$3 WHERE "users"."deleted_at" IS NULL AND "id" = $4`)).
+		WithArgs("new@test.com", nil, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	router.PUT("/admin/users/:id", UpdateUser)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/admin/users/3", bytes.NewBufferString(`{"email":"new@test.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email_verified_at":null`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 // Test GetUserPublicAnimeList Endpoint
 func TestGetUserPublicAnimeList(t *testing.T) {
 	mock, cleanup := SetupTestDB(t)
@@ -140,8 +208,8 @@ func TestGetUserPublicAnimeList(t *testing.T) {
 	// Mock DB Expectations
 	// 1. Find the target user by username
 	// Revert to specific username string
-	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).
-		WithArgs(targetUsername).
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT $2`)).
+		WithArgs(targetUsername, 1).
 		WillReturnRows(sqlmock.NewRows([]string{
 			"id", "created_at", "updated_at", "deleted_at",
 			"username", "password", "email", "role", "profile_picture",
@@ -158,20 +226,13 @@ func TestGetUserPublicAnimeList(t *testing.T) {
 		WithArgs(int64(targetUserID)). // Use int64
 		WillReturnRows(listRows)
 
-	// 3. Find anime cache details for each list item
-	animeRows1 := sqlmock.NewRows([]string{"id", "title", "cover_image", "format", "total_episodes"}).
-		AddRow(animeID1, "Anime Title 1", "cover1.jpg", "TV", 12)
-	// Use int64 for anime ID if animeID1 is int
-	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE "anime_caches"."id" = $1 AND "anime_caches"."deleted_at" IS NULL ORDER BY "anime_caches"."id" LIMIT 1`)).
-		WithArgs(int64(animeID1)). // Use int64
-		WillReturnRows(animeRows1)
-
-	animeRows2 := sqlmock.NewRows([]string{"id", "title", "cover_image", "format", "total_episodes"}).
+	// 3. Find anime cache details for all list items at once
+	animeRows := sqlmock.NewRows([]string{"id", "title", "cover_image", "format", "total_episodes"}).
+		AddRow(animeID1, "Anime Title 1", "cover1.jpg", "TV", 12).
 		AddRow(animeID2, "Anime Title 2", "cover2.jpg", "MOVIE", 1)
-	// Use int64 for anime ID if animeID2 is int
-	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE "anime_caches"."id" = $1 AND "anime_caches"."deleted_at" IS NULL ORDER BY "anime_caches"."id" LIMIT 1`)).
-		WithArgs(int64(animeID2)). // Use int64
-		WillReturnRows(animeRows2)
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "anime_caches" WHERE id IN ($1,$2) AND "anime_caches"."deleted_at" IS NULL`)).
+		WithArgs(animeID1, animeID2).
+		WillReturnRows(animeRows)
 
 	// Setup Route
 	router.GET("/users/:username/animelist", GetUserPublicAnimeList)
@@ -205,3 +266,118 @@ func TestGetUserPublicAnimeList(t *testing.T) {
 
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+// Test Signup rejects invalid input with one error per field
+func TestSignupValidation(t *testing.T) {
+	_, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	router.POST("/signup", Signup)
+
+	requestBody, _ := json.Marshal(gin.H{"username": "a!", "password": "short", "email": "not-an-email"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var responseBody struct {
+		Errors []validation.FieldError `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+
+	codes := map[string]string{}
+	for _, fieldErr := range responseBody.Errors {
+		codes[fieldErr.Field] = fieldErr.Code
+	}
+	assert.Equal(t, map[string]string{"username": "username", "password": "password", "email": "email"}, codes)
+}
+
+// Test Signup maps a duplicate username to 409 Conflict
+func TestSignupDuplicateUsername(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(EscapeQuery(`INSERT INTO "users"`)).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"})
+	mock.ExpectRollback()
+
+	router.POST("/signup", Signup)
+
+	requestBody, _ := json.Marshal(gin.H{"username": "taken_name", "password": "s3cure-password"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/signup", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var responseBody struct {
+		Errors []validation.FieldError `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	if assert.Len(t, responseBody.Errors, 1) {
+		assert.Equal(t, "username", responseBody.Errors[0].Field)
+		assert.Equal(t, "taken", responseBody.Errors[0].Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test Login answers an unknown username exactly like a wrong password
+func TestLoginUnknownUser(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE username = $1`)).
+		WithArgs("nobody", 1).
+		WillReturnRows(sqlmock.NewRows(userColumns))
+
+	router.POST("/login", Login)
+
+	requestBody, _ := json.Marshal(gin.H{"username": "nobody", "password": "whatever1"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid username or password")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Test a failed login past the threshold locks the account
+func TestLoginLocksAccountAfterFailures(t *testing.T) {
+	mock, cleanup := SetupTestDB(t)
+	defer cleanup()
+	router := SetupGin()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right-password1"), bcrypt.MinCost)
+	now := time.Now()
+	columns := append(append([]string{}, userColumns...), "failed_login_attempts", "locked_until")
+	mock.ExpectQuery(EscapeQuery(`SELECT * FROM "users" WHERE username = $1`)).
+		WithArgs("victim", 1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, now, now, nil, "victim", string(hash), nil, nil, "user", "default.jpg", config.AppSettings.Auth.MaxFailedLogins-1, nil))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(EscapeQuery(`UPDATE "users" SET "failed_login_attempts"=failed_login_attempts + 1,"locked_until"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	router.POST("/login", Login)
+
+	requestBody, _ := json.Marshal(gin.H{"username": "victim", "password": "wrong-password1"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid username or password")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,3 @@
+UPDATE users SET role = 'user' WHERE role IS NULL OR role = '';
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'user';
+ALTER TABLE users ALTER COLUMN role SET NOT NULL;
@@ -0,0 +1,2 @@
+-- Nothing to undo: NULL emails are valid in the previous schema as well
+SELECT 1;
@@ -0,0 +1,2 @@
+-- Empty strings collide on the unique constraint, accounts without an email store NULL
+UPDATE users SET email = NULL WHERE email = '';
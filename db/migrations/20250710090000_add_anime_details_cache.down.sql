@@ -0,0 +1,3 @@
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS last_fetched_at;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS details;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS status;
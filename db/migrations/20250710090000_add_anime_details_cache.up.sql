@@ -0,0 +1,3 @@
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS status VARCHAR(20);
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS details JSONB;
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS last_fetched_at TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS anime_studios;
+DROP TABLE IF EXISTS studios;
+DROP TABLE IF EXISTS anime_genres;
+DROP TABLE IF EXISTS genres;
+DROP INDEX IF EXISTS idx_anime_caches_season;
+DROP INDEX IF EXISTS idx_anime_caches_status;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS season_year;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS season;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS title_native;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS title_english;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS title_romaji;
@@ -0,0 +1,82 @@
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS title_romaji VARCHAR(255);
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS title_english VARCHAR(255);
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS title_native VARCHAR(255);
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS season VARCHAR(10);
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS season_year INT;
+CREATE INDEX IF NOT EXISTS idx_anime_caches_status ON anime_caches(status);
+CREATE INDEX IF NOT EXISTS idx_anime_caches_season ON anime_caches(season_year, season);
+
+CREATE TABLE IF NOT EXISTS genres (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_genres_name ON genres(name);
+
+CREATE TABLE IF NOT EXISTS anime_genres (
+    anime_id INT NOT NULL,
+    genre_id INT NOT NULL,
+    PRIMARY KEY (anime_id, genre_id),
+    CONSTRAINT fk_anime_genres_anime FOREIGN KEY (anime_id) REFERENCES anime_caches(id) ON DELETE CASCADE,
+    CONSTRAINT fk_anime_genres_genre FOREIGN KEY (genre_id) REFERENCES genres(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_anime_genres_genre_id ON anime_genres(genre_id);
+
+CREATE TABLE IF NOT EXISTS studios (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_studios_name ON studios(name);
+
+CREATE TABLE IF NOT EXISTS anime_studios (
+    anime_id INT NOT NULL,
+    studio_id INT NOT NULL,
+    PRIMARY KEY (anime_id, studio_id),
+    CONSTRAINT fk_anime_studios_anime FOREIGN KEY (anime_id) REFERENCES anime_caches(id) ON DELETE CASCADE,
+    CONSTRAINT fk_anime_studios_studio FOREIGN KEY (studio_id) REFERENCES studios(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_anime_studios_studio_id ON anime_studios(studio_id);
+
+-- Fill the new columns from the details cached so far; the refresh job and new fetches keep them current
+UPDATE anime_caches SET
+    title = COALESCE(NULLIF(details->'title'->>'english', ''), details->'title'->>'romaji', title),
+    title_romaji = details->'title'->>'romaji',
+    title_english = NULLIF(details->'title'->>'english', ''),
+    title_native = details->'title'->>'native',
+    season = NULLIF(details->>'season', ''),
+    season_year = NULLIF((details->>'seasonYear')::INT, 0)
+WHERE details IS NOT NULL;
+
+-- Only arrays are expanded; details of anime without genres or studios hold null there
+INSERT INTO genres (name)
+SELECT DISTINCT genre.name
+FROM anime_caches
+CROSS JOIN LATERAL jsonb_array_elements_text(
+    CASE WHEN jsonb_typeof(details->'genres') = 'array' THEN details->'genres' ELSE '[]' END
+) AS genre(name)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO anime_genres (anime_id, genre_id)
+SELECT anime_caches.id, genres.id
+FROM anime_caches
+CROSS JOIN LATERAL jsonb_array_elements_text(
+    CASE WHEN jsonb_typeof(details->'genres') = 'array' THEN details->'genres' ELSE '[]' END
+) AS genre(name)
+JOIN genres ON genres.name = genre.name
+ON CONFLICT DO NOTHING;
+
+INSERT INTO studios (name)
+SELECT DISTINCT studio->>'name'
+FROM anime_caches
+CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(details->'studios'->'nodes') = 'array' THEN details->'studios'->'nodes' ELSE '[]' END
+) AS studio
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO anime_studios (anime_id, studio_id)
+SELECT DISTINCT anime_caches.id, studios.id
+FROM anime_caches
+CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(details->'studios'->'nodes') = 'array' THEN details->'studios'->'nodes' ELSE '[]' END
+) AS studio
+JOIN studios ON studios.name = studio->>'name'
+ON CONFLICT DO NOTHING;
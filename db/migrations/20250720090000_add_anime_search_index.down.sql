@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS anime_caches_search_update ON anime_caches;
+DROP FUNCTION IF EXISTS anime_caches_search_update();
+DROP INDEX IF EXISTS idx_anime_caches_search_text;
+DROP INDEX IF EXISTS idx_anime_caches_search_vector;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS search_text;
+ALTER TABLE anime_caches DROP COLUMN IF EXISTS synonyms;
+-- pg_trgm is left installed, other objects may use it
@@ -0,0 +1,33 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS synonyms TEXT[] NOT NULL DEFAULT '{}';
+-- Maintained by the trigger below: every title variant and synonym, lower-cased for trigram matching
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS search_text TEXT;
+ALTER TABLE anime_caches ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+-- The 'simple' configuration does no stemming, titles are names in several languages
+CREATE OR REPLACE FUNCTION anime_caches_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_text := lower(concat_ws(' ',
+        NEW.title, NEW.title_english, NEW.title_romaji, NEW.title_native, array_to_string(NEW.synonyms, ' ')));
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', concat_ws(' ', NEW.title, NEW.title_english, NEW.title_romaji)), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.title_native, '')), 'B') ||
+        setweight(to_tsvector('simple', array_to_string(NEW.synonyms, ' ')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS anime_caches_search_update ON anime_caches;
+CREATE TRIGGER anime_caches_search_update
+    BEFORE INSERT OR UPDATE ON anime_caches
+    FOR EACH ROW EXECUTE FUNCTION anime_caches_search_update();
+
+CREATE INDEX IF NOT EXISTS idx_anime_caches_search_vector ON anime_caches USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_anime_caches_search_text ON anime_caches USING GIN (search_text gin_trgm_ops);
+
+-- Fill the synonyms of cached details, which also runs the trigger for every row
+UPDATE anime_caches SET synonyms = CASE
+    WHEN jsonb_typeof(details->'synonyms') = 'array' THEN ARRAY(SELECT jsonb_array_elements_text(details->'synonyms'))
+    ELSE synonyms
+END;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_user_anime_lists_user_anime;
+DROP INDEX IF EXISTS idx_anime_caches_title_romaji_prefix;
+DROP INDEX IF EXISTS idx_anime_caches_title_prefix;
@@ -0,0 +1,6 @@
+-- Autocomplete matches the start of the display and romaji titles with LIKE 'prefix%'
+CREATE INDEX IF NOT EXISTS idx_anime_caches_title_prefix ON anime_caches (lower(title) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_anime_caches_title_romaji_prefix ON anime_caches (lower(title_romaji) text_pattern_ops);
+
+-- Suggestions are boosted by the user's own list entries for the candidates
+CREATE INDEX IF NOT EXISTS idx_user_anime_lists_user_anime ON user_anime_lists (user_id, anime_external_id);
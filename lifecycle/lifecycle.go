@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// hook stops a single component during shutdown
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager stops registered components in reverse registration order,
+// so something registered after its dependencies is stopped before them
+// (e.g. the HTTP server before background workers, workers before the DB pool).
+type Manager struct {
+	mu    sync.Mutex
+	hooks []hook
+	done  bool
+}
+
+// OnShutdown registers a stop function for the named component
+func (m *Manager) OnShutdown(name string, stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// Shutdown runs every stop function once, even if earlier ones fail.
+// All hooks share ctx, so its deadline bounds the whole shutdown.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.done {
+		m.mu.Unlock()
+		return nil
+	}
+	m.done = true
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		log.Printf("Stopping %s...", h.name)
+		if err := h.stop(ctx); err != nil {
+			log.Printf("Failed to stop %s: %v", h.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		log.Printf("Stopped %s", h.name)
+	}
+
+	return errors.Join(errs...)
+}
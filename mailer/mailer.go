@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vrstep/wawatch-backend/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails; swap implementations per environment
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New builds the mailer selected by settings.Driver
+func New(settings config.MailSettings) (Mailer, error) {
+	switch settings.Driver {
+	case "smtp":
+		return NewSMTPMailer(settings), nil
+	case "file":
+		return NewFileMailer(settings.FileDir, settings.From)
+	case "memory", "":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", settings.Driver)
+	}
+}
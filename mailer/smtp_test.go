@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/config"
+)
+
+// silentRelay accepts connections and never answers, like a hung SMTP server
+func silentRelay(t *testing.T) (host string, port int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// Test a relay that does not answer fails the send once the timeout passes
+func TestSMTPMailerTimesOut(t *testing.T) {
+	host, port := silentRelay(t)
+	m := NewSMTPMailer(config.MailSettings{From: "no-reply@example.com", SMTPHost: host, SMTPPort: port, SMTPTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	err := m.Send(context.Background(), Message{To: "someone@example.com", Subject: "Hi", Body: "Hello"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// Test a cancelled context ends the send before the timeout
+func TestSMTPMailerStopsWithContext(t *testing.T) {
+	host, port := silentRelay(t)
+	m := NewSMTPMailer(config.MailSettings{From: "no-reply@example.com", SMTPHost: host, SMTPPort: port, SMTPTimeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := m.Send(ctx, Message{To: "someone@example.com", Subject: "Hi", Body: "Hello"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
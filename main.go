@@ -1,23 +1,73 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/api"
 	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/controller"
+	"github.com/vrstep/wawatch-backend/lifecycle"
+	"github.com/vrstep/wawatch-backend/mailer"
 	"github.com/vrstep/wawatch-backend/middleware"
 	"github.com/vrstep/wawatch-backend/routes"
+	"github.com/vrstep/wawatch-backend/worker"
 )
 
 func main() {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.AppSettings = settings
+
+	if settings.IsProduction() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	mail, err := mailer.New(settings.Mail)
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	controller.SetMailer(mail)
+	controller.SetAniListClient(api.NewAniListClient(settings.AniList))
+	controller.SetAniListOAuth(api.NewAniListOAuth(settings.AniList))
+
 	router := gin.New()
-	router.Use(middleware.Logging())
+	// Without trusted proxies X-Forwarded-For is ignored, so clients cannot choose their IP
+	if err := router.SetTrustedProxies(settings.HTTP.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(middleware.Logging(), middleware.ErrorHandler(), middleware.Recover())
+
+	app := &lifecycle.Manager{}
+
+	config.ConnectDB(settings.Database)
+	app.OnShutdown("database", func(ctx context.Context) error {
+		return config.CloseDB()
+	})
 
-	config.ConnectDB()
+	scheduler := worker.NewScheduler()
+	scheduler.Add(worker.Job{Name: controller.JobAccountPurge, Interval: settings.Account.PurgeInterval, Run: controller.PurgeDeletedAccounts})
+	scheduler.Add(worker.Job{Name: controller.JobAnimeRefresh, Interval: settings.Cache.RefreshInterval, Run: controller.RefreshAiringAnime})
+	scheduler.Start()
+	controller.SetScheduler(scheduler)
+	// Stopped before the database is closed so running jobs can finish their queries
+	app.OnShutdown("workers", scheduler.Stop)
+	// Likewise for work requests left running after their response, e.g. cache refreshes and mail
+	app.OnShutdown("background tasks", controller.StopBackgroundTasks)
 
 	// Apply CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -30,10 +80,54 @@ func main() {
 
 	// Setup routes
 	routes.UserRoute(router)
+	routes.AuthRoute(router)
 	routes.AnimeRoute(router)
 	routes.UserAnimeListRoute(router)
 	routes.ProviderRoute(router)
+	routes.AdminRoute(router)
+
+	server := &http.Server{
+		Addr:              settings.HTTP.Addr,
+		Handler:           router,
+		ReadTimeout:       settings.HTTP.ReadTimeout,
+		ReadHeaderTimeout: settings.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      settings.HTTP.WriteTimeout,
+		IdleTimeout:       settings.HTTP.IdleTimeout,
+	}
+	// Registered last so it is stopped first: no new requests reach workers or the DB
+	app.OnShutdown("http server", server.Shutdown)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", settings.HTTP.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, draining requests...")
+	case err := <-serverErr:
+		log.Printf("Server stopped: %v", err)
+		exitCode = 1
+	}
+	stop() // A second signal kills the process immediately
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown finished with errors: %v", err)
+		exitCode = 1
+	}
+	log.Println("Shutdown complete")
 
-	router.Run(":8080")
-	router.Run(":8081")
+	if exitCode != 0 {
+		cancel()
+		os.Exit(exitCode)
+	}
 }
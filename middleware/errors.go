@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/validation"
+)
+
+// ErrorResponse is the body of every error response.
+// "error" stays a plain message so older clients keep working.
+type ErrorResponse struct {
+	Error     string                  `json:"error"`
+	Code      apperr.Kind             `json:"code"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+// ErrorHandler renders the last error a handler attached with c.Error.
+// Internal details are logged with the request ID and never returned to the client.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+
+		appErr := apperr.From(c.Errors.Last().Err)
+		requestID := c.GetString("RequestID")
+
+		if appErr.Err != nil || appErr.Status() >= 500 {
+			log.Printf("[RequestID: %s] %s %s - %d %s: %v",
+				requestID, c.Request.Method, c.Request.URL.Path, appErr.Status(), appErr.Kind, appErr)
+		}
+
+		if c.Writer.Written() {
+			return
+		}
+
+		c.JSON(appErr.Status(), ErrorResponse{
+			Error:     appErr.Message,
+			Code:      appErr.Kind,
+			RequestID: requestID,
+			Errors:    appErr.Fields,
+		})
+	}
+}
+
+// errNotAuthenticated is returned by the auth middlewares whatever the reason,
+// so clients cannot tell an unknown session from an expired token
+var errNotAuthenticated = apperr.Unauthorized("Not authenticated")
+
+// abortWithError stops the chain and leaves the error for ErrorHandler to render.
+// The status is set right away so the response code is right even without ErrorHandler.
+func abortWithError(c *gin.Context, err *apperr.Error) {
+	c.Error(err)
+	c.Status(err.Status())
+	c.Abort()
+}
+
+// Recover turns panics into internal errors rendered by ErrorHandler
+func Recover() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		abortWithError(c, apperr.Internal("Internal server error", fmt.Errorf("panic: %v", recovered)))
+	})
+}
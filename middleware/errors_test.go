@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/apperr"
+)
+
+// serveError runs handler behind Logging, ErrorHandler and Recover and decodes the response
+func serveError(t *testing.T, handler gin.HandlerFunc) (*httptest.ResponseRecorder, ErrorResponse) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Logging(), ErrorHandler(), Recover())
+	router.GET("/", handler)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+
+	var body ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w, body
+}
+
+func TestErrorHandlerRendersEnvelope(t *testing.T) {
+	w, body := serveError(t, func(c *gin.Context) {
+		c.Error(apperr.NotFound("Anime not found"))
+	})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Anime not found", body.Error)
+	assert.Equal(t, apperr.KindNotFound, body.Code)
+	assert.NotEmpty(t, body.RequestID)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), body.RequestID)
+}
+
+func TestErrorHandlerHidesInternalDetails(t *testing.T) {
+	w, body := serveError(t, func(c *gin.Context) {
+		c.Error(errors.New("pq: password authentication failed"))
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, apperr.KindInternal, body.Code)
+	assert.NotContains(t, w.Body.String(), "password authentication")
+}
+
+func TestRecoverRendersPanicsAsInternalErrors(t *testing.T) {
+	w, body := serveError(t, func(c *gin.Context) {
+		panic("boom")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, apperr.KindInternal, body.Code)
+	assert.NotContains(t, w.Body.String(), "boom")
+}
@@ -13,6 +13,7 @@ func Logging() gin.HandlerFunc {
 		start := time.Now()
 		requestID := uuid.New().String()
 		c.Set("RequestID", requestID)
+		c.Header("X-Request-ID", requestID)
 
 		c.Next()
 
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/ratelimit"
+)
+
+// KeyFunc picks the bucket a request is counted against
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per authenticated user, falling back to the IP for anonymous ones.
+// It must run after RequireAuth to see the user.
+func ByUser(c *gin.Context) string {
+	if user, ok := currentUser(c); ok {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return ByIP(c)
+}
+
+// RateLimit rejects requests with 429 once the bucket picked by key is empty.
+// name separates the buckets of different limits so e.g. /login and /signup are counted apart.
+// If the store fails the request is let through: an outage of the limiter must not take the API down.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit.IsZero() {
+			c.Next()
+			return
+		}
+
+		result, err := store.Take(c.Request.Context(), name+":"+key(c), limit)
+		if err != nil {
+			log.Printf("Rate limiter unavailable for %s: %v", name, err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			abortWithError(c, apperr.TooManyRequests("Too many requests, try again later"))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/models"
+	"github.com/vrstep/wawatch-backend/ratelimit"
+	"gorm.io/gorm"
+)
+
+func TestRateLimitRejectsOnceBucketIsEmpty(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	limit := ratelimit.Limit{Burst: 1, Period: time.Minute}
+	router.GET("/", RateLimit(ratelimit.NewMemoryStore(), "test", limit, ByIP), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+
+	w := serve()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"code":"rate_limited"`)
+}
+
+// Test ByUser gives every user their own bucket, even behind the same IP
+func TestRateLimitByUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	limit := ratelimit.Limit{Burst: 1, Period: time.Minute}
+	router.GET("/", func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.Query("user")); err == nil {
+			c.Set("user", models.User{Model: gorm.Model{ID: uint(id)}})
+		}
+	}, RateLimit(ratelimit.NewMemoryStore(), "test", limit, ByUser), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(query string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/"+query, nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("?user=1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("?user=1"))
+	assert.Equal(t, http.StatusOK, serve("?user=2"))
+	// Anonymous requests are counted by IP
+	assert.Equal(t, http.StatusOK, serve(""))
+	assert.Equal(t, http.StatusTooManyRequests, serve(""))
+}
+
+// Test a spoofed X-Forwarded-For does not reset the bucket unless the peer is a trusted proxy
+func TestRateLimitIgnoresUntrustedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := ratelimit.Limit{Burst: 1, Period: time.Minute}
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		router := gin.New()
+		assert.NoError(t, router.SetTrustedProxies(trustedProxies))
+		router.Use(ErrorHandler())
+		router.GET("/", RateLimit(ratelimit.NewMemoryStore(), "test", limit, ByIP), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+	serve := func(router *gin.Engine, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	router := newRouter(nil)
+	assert.Equal(t, http.StatusOK, serve(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "198.51.100.2"))
+
+	// Behind a trusted proxy every forwarded client has its own bucket
+	router = newRouter([]string{"192.0.2.0/24"})
+	assert.Equal(t, http.StatusOK, serve(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusOK, serve(router, "198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "198.51.100.2"))
+}
@@ -2,55 +2,164 @@ package middleware
 
 import (
 	"fmt"
-	"net/http"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
 	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/models"
 )
 
-func RequireAuth(c *gin.Context) {
-	tokenString, err := c.Cookie("Auth")
-	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
+const (
+	// AuthCookieName is the cookie Login stores the access token in
+	AuthCookieName = "Auth"
+	// RefreshCookieName is the cookie holding the refresh token, only sent to /auth
+	RefreshCookieName = "Refresh"
+	// APIKeyHeader carries a personal API key instead of a session token
+	APIKeyHeader = "X-API-Key"
+)
+
+// apiKeyTouchInterval limits how often last_used_at of an API key is written
+const apiKeyTouchInterval = time.Minute
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// tokenFromRequest reads the JWT from "Authorization: Bearer <jwt>",
+// falling back to the Auth cookie for browser clients
+func tokenFromRequest(c *gin.Context) (string, bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
 		}
-		return []byte("secret"), nil // Ensure this matches the key used in Login
-	})
+		token = strings.TrimSpace(token)
+		return token, token != ""
+	}
+
+	token, err := c.Cookie(AuthCookieName)
+	if err != nil || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// RequireAuth lets requests with a valid session token through.
+// API keys are refused: only routes guarded by RequireAuthWithScope accept them.
+func RequireAuth(c *gin.Context) {
+	authenticate(c, "")
+}
 
-	if err != nil || !token.Valid {
-		c.AbortWithStatus(http.StatusUnauthorized)
+// RequireAuthWithScope lets requests with a valid session token or an API key
+// granted the scope through
+func RequireAuthWithScope(scope auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticate(c, scope)
+	}
+}
+
+func authenticate(c *gin.Context, scope auth.Scope) {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		authenticateAPIKey(c, key, scope)
 		return
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claims["exp"] == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	tokenString, ok := tokenFromRequest(c)
+	if !ok {
+		abortWithError(c, errNotAuthenticated)
 		return
 	}
 
-	// Check token expiration
-	if exp, ok := claims["exp"].(float64); !ok || float64(time.Now().Unix()) > exp {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	user, session, ok := sessionUser(tokenString)
+	if !ok {
+		abortWithError(c, errNotAuthenticated)
 		return
 	}
 
+	// Set user and session in context
+	c.Set("user", user)
+	c.Set("session", session)
+	c.Next()
+}
+
+// OptionalAuth sets "user" and "session" in the context when the request carries a
+// valid session token, and lets every request through. Public endpoints use it to
+// personalise their responses; API keys are ignored.
+func OptionalAuth(c *gin.Context) {
+	if tokenString, ok := tokenFromRequest(c); ok {
+		if user, session, ok := sessionUser(tokenString); ok {
+			c.Set("user", user)
+			c.Set("session", session)
+		}
+	}
+	c.Next()
+}
+
+// sessionUser returns the user and the active session the access token belongs to
+func sessionUser(tokenString string) (models.User, models.Session, bool) {
+	claims, err := auth.ParseAccessToken(tokenString)
+	if err != nil {
+		return models.User{}, models.Session{}, false
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return models.User{}, models.Session{}, false
+	}
+
+	// The token must belong to a session that has not been revoked (logout, reuse detection, ...)
+	var session models.Session
+	if err := config.DB.First(&session, "id = ? AND user_id = ?", claims.SessionID, userID).Error; err != nil {
+		return models.User{}, models.Session{}, false
+	}
+	if !session.IsActive(time.Now()) {
+		return models.User{}, models.Session{}, false
+	}
+
 	// Retrieve user from database
 	var user models.User
-	config.DB.First(&user, "id = ?", claims["sub"])
+	config.DB.First(&user, "id = ?", userID)
 	if user.ID == 0 {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		return models.User{}, models.Session{}, false
+	}
+	return user, session, true
+}
+
+// authenticateAPIKey checks the key and its scope and sets "user" and "api_key" in the context
+func authenticateAPIKey(c *gin.Context, key string, scope auth.Scope) {
+	now := time.Now()
+	var apiKey models.APIKey
+	if err := config.DB.Where("key_hash = ?", auth.HashToken(key)).First(&apiKey).Error; err != nil {
+		abortWithError(c, errNotAuthenticated)
+		return
+	}
+	if !apiKey.IsActive(now) {
+		abortWithError(c, errNotAuthenticated)
 		return
 	}
 
-	// Set user in context
+	if scope == "" {
+		abortWithError(c, apperr.Forbidden("API keys cannot be used for this endpoint"))
+		return
+	}
+	if !apiKey.HasScope(string(scope)) {
+		abortWithError(c, apperr.Forbidden(fmt.Sprintf("API key is missing the %s scope", scope)))
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", apiKey.UserID).Error; err != nil {
+		abortWithError(c, errNotAuthenticated)
+		return
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := config.DB.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Failed to record use of API key %d: %v", apiKey.ID, err)
+		}
+	}
+
 	c.Set("user", user)
+	c.Set("api_key", apiKey)
 	c.Next()
 }
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenFromRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name      string
+		header    string
+		cookie    string
+		wantToken string
+		wantOK    bool
+	}{
+		{name: "bearer header", header: "Bearer header-token", wantToken: "header-token", wantOK: true},
+		{name: "scheme is case insensitive", header: "bearer header-token", wantToken: "header-token", wantOK: true},
+		{name: "header wins over cookie", header: "Bearer header-token", cookie: "cookie-token", wantToken: "header-token", wantOK: true},
+		{name: "cookie fallback", cookie: "cookie-token", wantToken: "cookie-token", wantOK: true},
+		{name: "other scheme rejected", header: "Basic dXNlcjpwYXNz", cookie: "cookie-token", wantOK: false},
+		{name: "empty bearer rejected", header: "Bearer ", wantOK: false},
+		{name: "nothing sent", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				c.Request.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: AuthCookieName, Value: tt.cookie})
+			}
+
+			token, ok := tokenFromRequest(c)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantToken, token)
+		})
+	}
+}
+
+func TestOptionalAuthLetsAnonymousRequestsThrough(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/", OptionalAuth, func(c *gin.Context) {
+		_, exists := c.Get("user")
+		c.JSON(http.StatusOK, gin.H{"authenticated": exists})
+	})
+
+	for _, header := range []string{"", "Bearer not-a-jwt"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"authenticated":false}`, w.Body.String())
+	}
+}
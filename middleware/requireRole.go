@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/apperr"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// RequireRole only lets users with one of the given roles through.
+// It must run after RequireAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			abortWithError(c, errNotAuthenticated)
+			return
+		}
+
+		role := auth.NormalizeRole(user.Role)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		abortWithError(c, apperr.Forbidden("Insufficient permissions"))
+	}
+}
+
+// RequirePermission only lets users whose role grants the permission through.
+// It must run after RequireAuth.
+func RequirePermission(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			abortWithError(c, errNotAuthenticated)
+			return
+		}
+
+		if !auth.HasPermission(user.Role, permission) {
+			abortWithError(c, apperr.Forbidden("Insufficient permissions"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// currentUser returns the user RequireAuth stored in the context
+func currentUser(c *gin.Context) (models.User, bool) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		return models.User{}, false
+	}
+	user, ok := userInterface.(models.User)
+	return user, ok
+}
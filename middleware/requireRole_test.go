@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+// serveAs runs the guard for a request made by a user with the given role
+func serveAs(role string, guard gin.HandlerFunc) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		c.Set("user", models.User{Role: role})
+	}, guard, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRequireRole(t *testing.T) {
+	guard := RequireRole(models.RoleAdmin)
+
+	assert.Equal(t, http.StatusOK, serveAs(models.RoleAdmin, guard))
+	assert.Equal(t, http.StatusForbidden, serveAs(models.RoleModerator, guard))
+	assert.Equal(t, http.StatusForbidden, serveAs(models.RoleUser, guard))
+	assert.Equal(t, http.StatusForbidden, serveAs("", guard)) // Legacy accounts count as regular users
+}
+
+func TestRequirePermission(t *testing.T) {
+	guard := RequirePermission(auth.PermManageProviders)
+
+	assert.Equal(t, http.StatusOK, serveAs(models.RoleAdmin, guard))
+	assert.Equal(t, http.StatusOK, serveAs(models.RoleModerator, guard))
+	assert.Equal(t, http.StatusForbidden, serveAs(models.RoleUser, guard))
+}
+
+func TestRequireRoleWithoutUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", RequireRole(models.RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -1,6 +1,11 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
 
 // Represents a minimal cache or reference to an anime from the external API
 type AnimeCache struct {
@@ -11,6 +16,46 @@ type AnimeCache struct {
 	CoverImage    string `json:"cover_image"`                              // URL to the cover image
 	Format        string `json:"format"`                                   // e.g., TV, MOVIE, OVA
 	TotalEpisodes *int   `json:"total_episodes"`                           // Pointer for nullable/unknown
-	// Add other frequently accessed, relatively static fields if needed
-	// LastFetched time.Time `json:"-"` // Track when details were last fetched from API (optional)
+	Status        string `json:"status,omitempty"`                         // AniList status, decides how long Details stay fresh
+
+	// All titles; Title is the English one, or the romaji one when there is no English title
+	TitleRomaji  string `json:"title_romaji,omitempty"`
+	TitleEnglish string `json:"title_english,omitempty"`
+	TitleNative  string `json:"title_native,omitempty"`
+	// Other names the anime is known by; searched together with the titles
+	Synonyms pq.StringArray `json:"synonyms,omitempty" gorm:"type:text[]"`
+
+	Season     string `json:"season,omitempty"` // WINTER, SPRING, SUMMER, FALL
+	SeasonYear *int   `json:"season_year,omitempty"`
+
+	Genres  []Genre  `json:"genres,omitempty" gorm:"many2many:anime_genres;joinForeignKey:AnimeID;joinReferences:GenreID"`
+	Studios []Studio `json:"studios,omitempty" gorm:"many2many:anime_studios;joinForeignKey:AnimeID;joinReferences:StudioID"`
+
+	// Full details as last fetched from AniList; nil for entries only created from search results
+	Details       *AnimeDetails `json:"-" gorm:"type:jsonb;serializer:json"`
+	LastFetchedAt *time.Time    `json:"-"` // When Details were fetched
+}
+
+// Genre is an AniList genre, e.g. "Action"
+type Genre struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex;not null"`
+}
+
+// Studio is an animation studio or producer credited on AniList
+type Studio struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex;not null"`
+}
+
+// AnimeGenre links an anime to one of its genres
+type AnimeGenre struct {
+	AnimeID int  `gorm:"primaryKey"`
+	GenreID uint `gorm:"primaryKey"`
+}
+
+// AnimeStudio links an anime to one of its studios
+type AnimeStudio struct {
+	AnimeID  int  `gorm:"primaryKey"`
+	StudioID uint `gorm:"primaryKey"`
 }
@@ -1,5 +1,7 @@
 package models
 
+import "github.com/lib/pq"
+
 // AnimeDetails represents comprehensive information about an anime
 type AnimeDetails struct {
 	ID    int `json:"id"`
@@ -8,6 +10,7 @@ type AnimeDetails struct {
 		English string `json:"english"`
 		Native  string `json:"native"`
 	} `json:"title"`
+	Synonyms    []string `json:"synonyms"`
 	Description string   `json:"description"`
 	Format      string   `json:"format"` // TV, MOVIE, OVA, etc.
 	Status      string   `json:"status"` // FINISHED, RELEASING, etc.
@@ -38,15 +41,173 @@ type AnimeDetails struct {
 			Name string `json:"name"`
 		} `json:"nodes"`
 	} `json:"studios"`
+
+	// Extended sections, only fetched for a single anime. The connections are nil
+	// when the details came from a batch query.
+	Tags              []MediaTag           `json:"tags,omitempty"`
+	Characters        *CharacterConnection `json:"characters,omitempty"`
+	Staff             *StaffConnection     `json:"staff,omitempty"`
+	Relations         *RelationConnection  `json:"relations,omitempty"`
+	Trailer           *Trailer             `json:"trailer,omitempty"`
+	ExternalLinks     []ExternalLink       `json:"externalLinks,omitempty"`
+	NextAiringEpisode *AiringEpisode       `json:"nextAiringEpisode,omitempty"`
+}
+
+// HasExtendedSections reports whether the details were fetched with the extended sections
+func (a *AnimeDetails) HasExtendedSections() bool {
+	return a.Characters != nil
+}
+
+// CopyExtendedSections takes the extended sections from other, for details fetched without them
+func (a *AnimeDetails) CopyExtendedSections(other *AnimeDetails) {
+	a.Tags = other.Tags
+	a.Characters = other.Characters
+	a.Staff = other.Staff
+	a.Relations = other.Relations
+	a.Trailer = other.Trailer
+	a.ExternalLinks = other.ExternalLinks
+}
+
+// MediaTag describes an anime; rank is how relevant the tag is, 0-100
+type MediaTag struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	Category         string `json:"category"`
+	Rank             int    `json:"rank"`
+	IsGeneralSpoiler bool   `json:"isGeneralSpoiler"`
+	IsMediaSpoiler   bool   `json:"isMediaSpoiler"` // Spoils this anime in particular
+	IsAdult          bool   `json:"isAdult"`
+}
+
+// PersonName is the name of a character or staff member
+type PersonName struct {
+	Full   string `json:"full"`
+	Native string `json:"native"`
+}
+
+// Image is a character or staff picture
+type Image struct {
+	Medium string `json:"medium"`
+}
+
+// CharacterConnection lists the main and supporting characters of an anime
+type CharacterConnection struct {
+	Edges []CharacterEdge `json:"edges"`
+}
+
+// CharacterEdge is a character with their role in the anime and their voice actors
+type CharacterEdge struct {
+	Role string `json:"role"` // MAIN, SUPPORTING or BACKGROUND
+	Node struct {
+		ID    int        `json:"id"`
+		Name  PersonName `json:"name"`
+		Image Image      `json:"image"`
+	} `json:"node"`
+	VoiceActors []VoiceActor `json:"voiceActors"`
+}
+
+// VoiceActor voices a character in one language
+type VoiceActor struct {
+	ID       int        `json:"id"`
+	Name     PersonName `json:"name"`
+	Language string     `json:"languageV2"`
+	Image    Image      `json:"image"`
+}
+
+// StaffConnection lists the people who worked on an anime
+type StaffConnection struct {
+	Edges []StaffEdge `json:"edges"`
+}
+
+// StaffEdge is a staff member and their role, e.g. "Director"
+type StaffEdge struct {
+	Role string `json:"role"`
+	Node struct {
+		ID    int        `json:"id"`
+		Name  PersonName `json:"name"`
+		Image Image      `json:"image"`
+	} `json:"node"`
+}
+
+// RelationConnection lists the anime and manga related to an anime
+type RelationConnection struct {
+	Edges []RelationEdge `json:"edges"`
 }
 
-// ToAnimeCache converts detailed anime info to a cache entry
+// RelationEdge is related media and how it relates, e.g. SEQUEL, PREQUEL or SIDE_STORY
+type RelationEdge struct {
+	RelationType string `json:"relationType"`
+	Node         struct {
+		ID     int    `json:"id"`
+		Type   string `json:"type"` // ANIME or MANGA
+		Format string `json:"format"`
+		Status string `json:"status"`
+		Title  struct {
+			Romaji  string `json:"romaji"`
+			English string `json:"english"`
+		} `json:"title"`
+		CoverImage Image `json:"coverImage"`
+	} `json:"node"`
+}
+
+// Trailer is a video on the site, e.g. "youtube" with the video ID
+type Trailer struct {
+	ID        string `json:"id"`
+	Site      string `json:"site"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// ExternalLink points to a streaming service, the official site, social media, ...
+type ExternalLink struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url"`
+	Site     string `json:"site"`
+	Type     string `json:"type"` // INFO, STREAMING or SOCIAL
+	Language string `json:"language"`
+}
+
+// AiringEpisode is the next episode to air; AiringAt is a Unix timestamp. AniList's
+// timeUntilAiring is left out, it would be outdated when served from the cache.
+type AiringEpisode struct {
+	Episode  int   `json:"episode"`
+	AiringAt int64 `json:"airingAt"`
+}
+
+// ToAnimeCache converts detailed anime info to a cache entry.
+// Genres and Studios only carry names; storing the entry links them by name.
 func (a *AnimeDetails) ToAnimeCache() AnimeCache {
-	return AnimeCache{
-		ID:            a.ID,
-		Title:         a.Title.English,
-		CoverImage:    a.CoverImage.Large,
-		Format:        a.Format,
-		TotalEpisodes: &a.Episodes,
+	title := a.Title.English
+	if title == "" {
+		title = a.Title.Romaji
+	}
+
+	cache := AnimeCache{
+		ID:           a.ID,
+		Title:        title,
+		TitleRomaji:  a.Title.Romaji,
+		TitleEnglish: a.Title.English,
+		TitleNative:  a.Title.Native,
+		Synonyms:     append(pq.StringArray{}, a.Synonyms...), // Never nil, the column is NOT NULL
+		CoverImage:   a.CoverImage.Large,
+		Format:       a.Format,
+		Status:       a.Status,
+		Season:       a.Season,
+	}
+	// AniList sends null for unknown values, which decode as 0
+	if a.Episodes > 0 {
+		episodes := a.Episodes
+		cache.TotalEpisodes = &episodes
+	}
+	if a.SeasonYear > 0 {
+		year := a.SeasonYear
+		cache.SeasonYear = &year
+	}
+	for _, genre := range a.Genres {
+		cache.Genres = append(cache.Genres, Genre{Name: genre})
+	}
+	for _, studio := range a.Studios.Nodes {
+		cache.Studios = append(cache.Studios, Studio{Name: studio.Name})
 	}
+	return cache
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// APIKey lets scripts act for a user through the X-API-Key header, limited to its scopes.
+// The key itself is shown once at creation; only its hash and visible prefix are stored.
+type APIKey struct {
+	gorm.Model
+	UserID     uint           `json:"-" gorm:"not null;index"`
+	Name       string         `json:"name" gorm:"not null"`
+	Prefix     string         `json:"prefix" gorm:"not null"`
+	KeyHash    string         `json:"-" gorm:"not null;uniqueIndex"`
+	Scopes     pq.StringArray `json:"scopes" gorm:"type:text[];not null"`
+	ExpiresAt  *time.Time     `json:"expires_at"` // Nil means the key does not expire
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	RevokedAt  *time.Time     `json:"revoked_at"`
+}
+
+// IsActive reports whether the key can still be used
+func (k APIKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// HasScope reports whether the key was granted the scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
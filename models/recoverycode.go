@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserRecoveryCode is a one-time code that replaces a TOTP code when the authenticator is lost.
+// Only the hash is stored; the codes are shown once when 2FA is confirmed.
+type UserRecoveryCode struct {
+	gorm.Model
+	UserID   uint       `gorm:"not null;index"`
+	CodeHash string     `gorm:"not null;size:64"`
+	UsedAt   *time.Time // Nil until used
+}
@@ -1,12 +1,43 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Roles a user can have, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
 
 type User struct {
 	gorm.Model
-	Username       string `json:"username" gorm:"unique;not null"`
-	Password       string `json:"password" gorm:"not null"`
-	Email          string `json:"email" gorm:"unique"`
-	Role           string `json:"role"`
-	ProfilePicture string `json:"profile_picture" gorm:"default:'default.jpg'"`
+	Username        string     `json:"username" gorm:"unique;not null"`
+	Password        string     `json:"-" gorm:"not null"`                // bcrypt hash, never serialized
+	Email           string     `json:"email" gorm:"unique;default:null"` // Stored as NULL when not given, so many users can have none
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`                // Nil until the address is confirmed
+	Role            string     `json:"role" gorm:"default:user"`
+	ProfilePicture  string     `json:"profile_picture" gorm:"default:'default.jpg'"`
+
+	FailedLoginAttempts int        `json:"-" gorm:"not null;default:0"` // Consecutive failures, reset by a successful login
+	LockedUntil         *time.Time `json:"-"`                           // Logins are refused until then
+
+	TOTPSecret    string     `json:"-"`               // Set during enrollment, only used once TOTPEnabledAt is set
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"` // Nil while two-factor authentication is off
+	TOTPLastStep  int64      `json:"-"`               // Last accepted TOTP time step, codes cannot be replayed
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"` // The account and its data are purged after this, unless the user logs in before
+}
+
+// TwoFactorEnabled reports whether logins need a second factor
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// IsLocked reports whether logins to the account are currently refused
+func (u User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
 }
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// External identity providers users can log in with
+const (
+	ProviderAniList = "anilist"
+)
+
+// UserIdentity links an account at an external provider to a user.
+// A user has at most one identity per provider and an external account belongs to one user.
+type UserIdentity struct {
+	gorm.Model
+	UserID           uint       `json:"-" gorm:"not null;index"`
+	Provider         string     `json:"provider" gorm:"not null"`
+	ProviderUserID   string     `json:"provider_user_id" gorm:"not null"`
+	ProviderUsername string     `json:"provider_username"`
+	AccessToken      string     `json:"-"` // Kept for syncing the user's AniList list later
+	RefreshToken     string     `json:"-"`
+	TokenExpiresAt   *time.Time `json:"token_expires_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purposes of single-use user tokens
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenAniListLink       = "anilist_link" // State nonce of an AniList account link, consumed on confirmation
+)
+
+// UserToken is a single-use, expiring token sent to a user by email, or handed out
+// to bind a flow to the user who started it. Only the hash is stored.
+type UserToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index"`
+	Purpose   string     `gorm:"type:varchar(32);not null"`
+	TokenHash string     `gorm:"uniqueIndex;not null"`
+	Email     string     // Address the token was sent to; verification only counts for that address
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Pointer for nullable
+}
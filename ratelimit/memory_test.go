@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Burst: 2, Period: time.Minute}
+	ctx := context.Background()
+
+	// The burst is available at once
+	for i := 0; i < 2; i++ {
+		result, err := store.Take(ctx, "k", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, _ := store.Take(ctx, "k", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 30*time.Second, result.RetryAfter)
+
+	// Other keys have their own bucket
+	result, _ = store.Take(ctx, "other", limit)
+	assert.True(t, result.Allowed)
+
+	// One token is back after Period/Burst
+	now = now.Add(30 * time.Second)
+	result, _ = store.Take(ctx, "k", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryStoreZeroLimitAllows(t *testing.T) {
+	result, err := NewMemoryStore().Take(context.Background(), "k", Limit{})
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -0,0 +1,42 @@
+// Package ratelimit implements token bucket rate limiting behind a pluggable store.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket: Burst requests at once, refilled at Burst per Period
+type Limit struct {
+	Burst  int           `yaml:"burst"`
+	Period time.Duration `yaml:"period"`
+}
+
+// PerMinute is a limit of n requests per minute
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Period: time.Minute}
+}
+
+// IsZero reports whether the limit is unset, which disables limiting
+func (l Limit) IsZero() bool {
+	return l.Burst <= 0 || l.Period <= 0
+}
+
+// refillRate is how many tokens are added per second
+func (l Limit) refillRate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed    bool
+	Remaining  int           // Whole tokens left in the bucket
+	RetryAfter time.Duration // When the next token is available, zero if allowed
+}
+
+// Store keeps the buckets. Implementations must be safe for concurrent use.
+// The interface is kept small so a shared store (e.g. Redis with a Lua script
+// doing the same refill arithmetic) can replace MemoryStore when running several instances.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/controller"
+	"github.com/vrstep/wawatch-backend/middleware"
+	"github.com/vrstep/wawatch-backend/models"
+)
+
+func AdminRoute(router *gin.Engine) {
+	admin := router.Group("/admin")
+	admin.Use(middleware.RequireAuth, middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("/users", controller.GetUsers)
+		admin.POST("/users", controller.CreateUser)
+		admin.GET("/users/:id", controller.GetUser)
+		admin.PUT("/users/:id", controller.UpdateUser)
+		admin.DELETE("/users/:id", controller.DeleteUser)
+
+		admin.GET("/stats/anilist", controller.GetAniListStats)
+
+		admin.GET("/jobs", controller.GetJobs)
+		admin.POST("/jobs/:name/run", controller.RunJob)
+	}
+}
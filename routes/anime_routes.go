@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/controller"
 	"github.com/vrstep/wawatch-backend/middleware"
 )
@@ -9,18 +11,22 @@ import (
 func AnimeRoute(router *gin.Engine) {
 	anime := router.Group("/anime")
 	{
-		anime.GET("/search", middleware.RequireAuth, controller.SearchAnime)
+		// Searching the local catalogue is public, searching AniList requires a logged-in user
+		anime.GET("/search", limitByIP("search", config.AppSettings.RateLimit.Search), middleware.OptionalAuth, controller.SearchAnime)
+		anime.GET("/autocomplete", limitByIP("autocomplete", config.AppSettings.RateLimit.Autocomplete), middleware.OptionalAuth, controller.AutocompleteAnime)
 		anime.GET("/:id", controller.GetAnimeDetails)
+		anime.GET("/:id/franchise", middleware.OptionalAuth, controller.GetAnimeFranchise)
 
 		// Public discovery endpoints
 		anime.GET("/popular", controller.GetPopularAnime)               // New Endpoint 4
 		anime.GET("/trending", controller.GetTrendingAnime)             // New Endpoint 5
 		anime.GET("/season/:year/:season", controller.GetAnimeBySeason) // New Endpoint 6
+		anime.GET("/browse", limitByIP("browse", config.AppSettings.RateLimit.Browse), controller.BrowseAnime)
 
 		// Protected routes
 		anime.POST("/provider", middleware.RequireAuth, controller.AddWatchProvider)
 		anime.GET("/recommendations", middleware.RequireAuth, controller.GetAnimeRecommendations) // New Endpoint 10
 
-		anime.GET("/:id/list-status", middleware.RequireAuth, controller.GetAnimeInUserList)
+		anime.GET("/:id/list-status", middleware.RequireAuthWithScope(auth.ScopeListRead), controller.GetAnimeInUserList)
 	}
 }
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/controller"
+	"github.com/vrstep/wawatch-backend/middleware"
+)
+
+func AuthRoute(router *gin.Engine) {
+	authGroup := router.Group("/auth")
+	authGroup.Use(limitByIP("auth", config.AppSettings.RateLimit.Auth))
+	{
+		authGroup.POST("/refresh", controller.RefreshToken)
+		authGroup.POST("/2fa/verify", controller.VerifyTwoFactor)
+
+		authGroup.GET("/anilist/login", controller.AniListLogin)
+		authGroup.GET("/anilist/callback", controller.AniListCallback)
+		authGroup.POST("/logout", middleware.RequireAuth, controller.Logout)
+
+		authGroup.POST("/forgot-password", controller.ForgotPassword)
+		authGroup.POST("/reset-password", controller.ResetPassword)
+		authGroup.POST("/verify-email", controller.VerifyEmail)
+		authGroup.POST("/verify-email/resend", middleware.RequireAuth, controller.ResendVerificationEmail)
+	}
+}
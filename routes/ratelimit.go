@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/config"
+	"github.com/vrstep/wawatch-backend/middleware"
+	"github.com/vrstep/wawatch-backend/ratelimit"
+)
+
+// rateLimitStore holds the buckets of every rate limited route
+var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// limitByIP rate limits a route per client IP; it lets everything through when rate limiting is disabled
+func limitByIP(name string, limit ratelimit.Limit) gin.HandlerFunc {
+	if !config.AppSettings.RateLimit.Enabled {
+		limit = ratelimit.Limit{}
+	}
+	return middleware.RateLimit(rateLimitStore, name, limit, middleware.ByIP)
+}
+
+// limitByUser rate limits a route per authenticated user, so every session and API key
+// of a user share the limit; it must come after the auth middleware
+func limitByUser(name string, limit ratelimit.Limit) gin.HandlerFunc {
+	if !config.AppSettings.RateLimit.Enabled {
+		limit = ratelimit.Limit{}
+	}
+	return middleware.RateLimit(rateLimitStore, name, limit, middleware.ByUser)
+}
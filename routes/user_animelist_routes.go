@@ -2,27 +2,35 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/controller"
 	"github.com/vrstep/wawatch-backend/middleware"
 )
 
 func UserAnimeListRoute(router *gin.Engine) {
+	// All routes require authentication; API keys need the matching list scope.
+	// Requests are then limited per user, whether made with a session or an API key.
+	read := middleware.RequireAuthWithScope(auth.ScopeListRead)
+	write := middleware.RequireAuthWithScope(auth.ScopeListWrite)
+	limitRead := limitByUser("list-read", config.AppSettings.RateLimit.ListRead)
+	limitWrite := limitByUser("list-write", config.AppSettings.RateLimit.ListWrite)
+
 	list := router.Group("/animelist")
-	list.Use(middleware.RequireAuth) // All routes require authentication
 	{
 		// Get user's anime list (optionally filtered by status)
-		list.GET("/", controller.GetUserAnimeList)
+		list.GET("/", read, limitRead, controller.GetUserAnimeList)
 
 		// Add anime to list or update if already exists
-		list.POST("/", controller.AddToAnimeList)
+		list.POST("/", write, limitWrite, controller.AddToAnimeList)
 
 		// Update a specific list entry
-		list.PATCH("/:id", controller.UpdateListEntry)
+		list.PATCH("/:id", write, limitWrite, controller.UpdateListEntry)
 
 		// Delete a list entry
-		list.DELETE("/:id", controller.DeleteListEntry)
+		list.DELETE("/:id", write, limitWrite, controller.DeleteListEntry)
 
-		list.GET("/stats", controller.GetUserAnimeListStats) // New Endpoint 3
+		list.GET("/stats", read, limitRead, controller.GetUserAnimeListStats) // New Endpoint 3
 
 	}
 }
@@ -2,22 +2,47 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/vrstep/wawatch-backend/auth"
+	"github.com/vrstep/wawatch-backend/config"
 	"github.com/vrstep/wawatch-backend/controller"
 	"github.com/vrstep/wawatch-backend/middleware"
 )
 
 func UserRoute(router *gin.Engine) {
 	// ...existing code...
-	router.POST("/signup", controller.Signup)
-	router.POST("/login", controller.Login)
+	limits := config.AppSettings.RateLimit
+	router.POST("/signup", limitByIP("signup", limits.Signup), controller.Signup)
+	router.POST("/login", limitByIP("login", limits.Login), controller.Login)
 	router.GET("/validate", middleware.RequireAuth, controller.Validate)
 
-	// Profile routes (require authentication)
+	// Reading the profile is also open to API keys with the profile:read scope
+	router.GET("/profile/", middleware.RequireAuthWithScope(auth.ScopeProfileRead), controller.GetMyProfile) // New Endpoint 1
+
+	// Profile routes (require a session)
 	profile := router.Group("/profile")
 	profile.Use(middleware.RequireAuth)
 	{
-		profile.GET("/", controller.GetMyProfile)    // New Endpoint 1
 		profile.PUT("/", controller.UpdateMyProfile) // New Endpoint 2
+
+		profile.GET("/sessions", controller.GetMySessions)
+		profile.DELETE("/sessions/:id", controller.RevokeMySession)
+
+		profile.POST("/2fa/setup", controller.SetupTwoFactor)
+		profile.POST("/2fa/confirm", controller.ConfirmTwoFactor)
+		profile.POST("/2fa/disable", controller.DisableTwoFactor)
+		profile.POST("/2fa/recovery-codes", controller.RegenerateRecoveryCodes)
+
+		profile.GET("/identities", controller.GetMyIdentities)
+		profile.POST("/identities/anilist", controller.LinkAniList)
+		profile.POST("/identities/anilist/confirm", controller.ConfirmAniListLink)
+		profile.DELETE("/identities/:provider", controller.UnlinkIdentity)
+
+		profile.GET("/api-keys", controller.GetMyAPIKeys)
+		profile.POST("/api-keys", controller.CreateAPIKey)
+		profile.DELETE("/api-keys/:id", controller.RevokeAPIKey)
+
+		profile.GET("/export", controller.ExportMyData)
+		profile.POST("/delete", controller.DeleteMyAccount)
 	}
 
 	// Public user list view
@@ -0,0 +1,140 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// FieldError describes why one input field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{2,31}$`)
+
+// Register the custom rules with gin's validator when the package is loaded
+func init() {
+	if err := Register(binding.Validator.Engine().(*validator.Validate)); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds the "username" and "password" rules and makes errors report JSON (or query parameter) field names
+func Register(v *validator.Validate) error {
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			// Query parameters are only named by their form tag
+			name, _, _ = strings.Cut(field.Tag.Get("form"), ",")
+		}
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	if err := v.RegisterValidation("username", isUsername); err != nil {
+		return err
+	}
+	return v.RegisterValidation("password", isStrongPassword)
+}
+
+// isUsername allows 3-32 letters, digits, "_", "." and "-", starting with a letter or digit
+func isUsername(fl validator.FieldLevel) bool {
+	return IsUsername(fl.Field().String())
+}
+
+// IsUsername reports whether s is acceptable as a username
+func IsUsername(s string) bool {
+	return usernamePattern.MatchString(s)
+}
+
+// isStrongPassword requires 8-72 bytes (bcrypt ignores anything longer) with at least one letter and one digit
+func isStrongPassword(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+	if len(password) < 8 || len(password) > 72 {
+		return false
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	return hasLetter && hasDigit
+}
+
+// FieldErrors converts a binding error into per-field errors.
+// Errors that are not about a specific field (malformed JSON, ...) are reported on "body".
+func FieldErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   fe.Field(),
+				Code:    fe.Tag(),
+				Message: message(fe),
+			})
+		}
+		return fields
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{Field: typeErr.Field, Code: "type", Message: fmt.Sprintf("must be a %s", typeErr.Type)}}
+	}
+
+	return []FieldError{{Field: "body", Code: "invalid", Message: "Request body is not valid JSON"}}
+}
+
+// message returns a human readable explanation for a failed rule
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "http_url", "url":
+		return "must be a valid http(s) URL"
+	case "username":
+		return "must be 3-32 characters of letters, digits, '_', '.' or '-', starting with a letter or digit"
+	case "password":
+		return "must be 8-72 characters and contain at least one letter and one digit"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	default:
+		return "is invalid"
+	}
+}
+
+// UniqueViolation reports whether err is a Postgres unique constraint violation and which constraint it hit
+func UniqueViolation(err error) (constraint string, ok bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
+}
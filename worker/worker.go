@@ -0,0 +1,229 @@
+// Package worker runs periodic background jobs next to the HTTP server.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownJob is returned by Trigger for names that were never added
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrJobRunning is returned by Trigger while the job is already running
+	ErrJobRunning = errors.New("job is already running")
+	// ErrNotStarted is returned by Trigger before Start or after Stop
+	ErrNotStarted = errors.New("scheduler is not running")
+)
+
+// Job is a task run every Interval until the scheduler stops
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus is what is known about a job's runs
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Interval       string     `json:"interval"`
+	Running        bool       `json:"running"`
+	Runs           int        `json:"runs"`
+	Failures       int        `json:"failures"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastFinishedAt *time.Time `json:"last_finished_at"`
+	LastDurationMS int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRunAt      *time.Time `json:"next_run_at"`
+}
+
+// entry is a job together with its status
+type entry struct {
+	job    Job
+	status JobStatus
+}
+
+// Scheduler runs jobs in their own goroutines; a job never runs twice at the same time.
+// Stop cancels the context passed to running jobs and waits for them to return.
+type Scheduler struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	order   []string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{entries: map[string]*entry{}}
+}
+
+// Add registers a job; it must be called before Start
+func (s *Scheduler) Add(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[job.Name] = &entry{job: job, status: JobStatus{Name: job.Name, Interval: job.Interval.String()}}
+	s.order = append(s.order, job.Name)
+}
+
+// Start launches every job; each one first runs after one interval
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx, s.cancel = ctx, cancel
+	for _, name := range s.order {
+		e := s.entries[name]
+		next := time.Now().Add(e.job.Interval)
+		e.status.NextRunAt = &next
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, e)
+		}()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	ticker := time.NewTicker(e.job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			next := time.Now().Add(e.job.Interval)
+			e.status.NextRunAt = &next
+			s.mu.Unlock()
+
+			if s.begin(e) {
+				s.run(ctx, e)
+			}
+		}
+	}
+}
+
+// Trigger starts a run of the named job now, without waiting for it to finish
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[name]
+	if !ok {
+		return ErrUnknownJob
+	}
+	if s.ctx == nil || s.ctx.Err() != nil {
+		return ErrNotStarted
+	}
+	if !s.beginLocked(e) {
+		return ErrJobRunning
+	}
+
+	// Added under the lock, so Stop cannot miss this run
+	s.wg.Add(1)
+	go func(ctx context.Context) {
+		defer s.wg.Done()
+		s.run(ctx, e)
+	}(s.ctx)
+	return nil
+}
+
+// Statuses returns the status of every job, in the order they were added
+func (s *Scheduler) Statuses() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.order))
+	for _, name := range s.order {
+		statuses = append(statuses, s.entries[name].status)
+	}
+	return statuses
+}
+
+// begin marks the job as running, or reports false if it already is
+func (s *Scheduler) begin(e *entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.beginLocked(e)
+}
+
+func (s *Scheduler) beginLocked(e *entry) bool {
+	if e.status.Running {
+		log.Printf("Job %s is still running, skipping this run", e.job.Name)
+		return false
+	}
+	now := time.Now()
+	e.status.Running = true
+	e.status.LastStartedAt = &now
+	return true
+}
+
+// run runs the job once and records the outcome; failures and panics are logged so one bad run does not end the loop
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	start := time.Now()
+	err := runJob(ctx, e.job)
+	finished := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.status.Running = false
+	e.status.Runs++
+	e.status.LastFinishedAt = &finished
+	e.status.LastDurationMS = finished.Sub(start).Milliseconds()
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	}
+}
+
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", job.Name, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Printf("Job %s failed after %s: %v", job.Name, time.Since(start), err)
+		return err
+	}
+	log.Printf("Job %s finished in %s", job.Name, time.Since(start))
+	return nil
+}
+
+// Stop cancels running jobs and waits until they returned or ctx expires
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel := s.cancel
+	if cancel != nil {
+		cancel()
+	}
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test Trigger runs a job once at a time and records its status
+func TestSchedulerTrigger(t *testing.T) {
+	release := make(chan struct{})
+	scheduler := NewScheduler()
+	scheduler.Add(Job{Name: "slow", Interval: time.Hour, Run: func(ctx context.Context) error {
+		<-release
+		return errors.New("upstream down")
+	}})
+
+	assert.ErrorIs(t, scheduler.Trigger("slow"), ErrNotStarted)
+
+	scheduler.Start()
+	defer scheduler.Stop(context.Background())
+
+	assert.ErrorIs(t, scheduler.Trigger("missing"), ErrUnknownJob)
+	assert.NoError(t, scheduler.Trigger("slow"))
+	assert.ErrorIs(t, scheduler.Trigger("slow"), ErrJobRunning)
+	assert.True(t, scheduler.Statuses()[0].Running)
+
+	close(release)
+	assert.Eventually(t, func() bool { return scheduler.Statuses()[0].Runs == 1 }, time.Second, time.Millisecond)
+
+	status := scheduler.Statuses()[0]
+	assert.False(t, status.Running)
+	assert.Equal(t, 1, status.Failures)
+	assert.Equal(t, "upstream down", status.LastError)
+	assert.NotNil(t, status.LastFinishedAt)
+	assert.NotNil(t, status.NextRunAt)
+}
+
+// Test Stop cancels running jobs and a panicking job is recorded as failed
+func TestSchedulerStop(t *testing.T) {
+	scheduler := NewScheduler()
+	scheduler.Add(Job{Name: "blocking", Interval: time.Hour, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	scheduler.Add(Job{Name: "panicking", Interval: time.Hour, Run: func(ctx context.Context) error {
+		panic("boom")
+	}})
+	scheduler.Start()
+
+	assert.NoError(t, scheduler.Trigger("blocking"))
+	assert.NoError(t, scheduler.Trigger("panicking"))
+	assert.Eventually(t, func() bool { return scheduler.Statuses()[1].Runs == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, "panic: boom", scheduler.Statuses()[1].LastError)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, scheduler.Stop(ctx))
+	assert.Equal(t, context.Canceled.Error(), scheduler.Statuses()[0].LastError)
+	assert.ErrorIs(t, scheduler.Trigger("blocking"), ErrNotStarted)
+}